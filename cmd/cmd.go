@@ -48,7 +48,9 @@ func (p *Cmd) Run() {
 		Config: p.config,
 		Logger: p.logger,
 	}
-	p.timer.Init()
+	if err := p.timer.Init(); err != nil {
+		p.logger.Error(err.Error(), true)
+	}
 	p.timer.Start()
 	// 信号处理
 	p.handleSignal()
@@ -56,6 +58,7 @@ func (p *Cmd) Run() {
 	<-p.exit
 	// 输出停止日志
 	p.logger.Info(fmt.Sprintf("Service stopped successfully, PID: %d", os.Getpid()))
+	p.logger.Flush()
 }
 
 // 欢迎信息
@@ -114,13 +117,13 @@ func (p *Cmd) writePidFile(pidFile string) {
 
 // 信号处理
 func (p *Cmd) handleSignal() {
-	ch := make(chan os.Signal)
+	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 	go func() {
 		sig := <-ch
 		switch sig {
 		case syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT:
-			p.timer.Stop()
+			p.timer.Close()
 			p.exit <- true
 		}
 	}()
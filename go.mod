@@ -3,6 +3,7 @@ module github.com/dcsunny/delayer
 go 1.15
 
 require (
+	github.com/alicebob/miniredis/v2 v2.30.5
 	github.com/gomodule/redigo v1.8.3
 	gopkg.in/ini.v1 v1.62.0
 )
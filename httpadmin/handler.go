@@ -0,0 +1,213 @@
+package httpadmin
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/dcsunny/delayer/logic"
+	"github.com/dcsunny/delayer/utils"
+)
+
+// 管理接口
+type Handler struct {
+	Admin *logic.Admin
+	mux   *http.ServeMux
+}
+
+// 创建实例
+func NewHandler(admin *logic.Admin) *Handler {
+	h := &Handler{
+		Admin: admin,
+		mux:   http.NewServeMux(),
+	}
+	h.mux.HandleFunc("/stats", h.get(h.stats))
+	h.mux.HandleFunc("/topics", h.get(h.topics))
+	h.mux.HandleFunc("/jobs", h.get(h.inspect))
+	h.mux.HandleFunc("/jobs/cancel", h.post(h.cancel))
+	h.mux.HandleFunc("/dead", h.get(h.listDead))
+	h.mux.HandleFunc("/dead/replay", h.post(h.replayDead))
+	h.mux.HandleFunc("/topics/held", h.get(h.heldTopics))
+	h.mux.HandleFunc("/topics/hold", h.post(h.holdTopic))
+	h.mux.HandleFunc("/topics/release", h.post(h.releaseTopic))
+	return h
+}
+
+// 处理请求
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// 统计: GET /stats
+func (h *Handler) stats(w http.ResponseWriter, r *http.Request) {
+	stats, err := h.Admin.Stats()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, stats)
+}
+
+// Topic列表: GET /topics
+func (h *Handler) topics(w http.ResponseWriter, r *http.Request) {
+	topics, err := h.Admin.Topics()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, topics)
+}
+
+// 查看任务: GET /jobs?id=
+func (h *Handler) inspect(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		writeError(w, http.StatusBadRequest, "id is required")
+		return
+	}
+	job, err := h.Admin.Inspect(id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if job == nil {
+		writeError(w, http.StatusNotFound, "job not found")
+		return
+	}
+	writeJSON(w, job)
+}
+
+// 取消任务: POST /jobs/cancel?id=
+func (h *Handler) cancel(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		writeError(w, http.StatusBadRequest, "id is required")
+		return
+	}
+	ok, err := h.Admin.Cancel(id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, "job not found")
+		return
+	}
+	writeJSON(w, map[string]bool{"canceled": true})
+}
+
+// 死信列表: GET /dead?topic=&limit=
+func (h *Handler) listDead(w http.ResponseWriter, r *http.Request) {
+	topic := r.URL.Query().Get("topic")
+	if topic == "" {
+		writeError(w, http.StatusBadRequest, "topic is required")
+		return
+	}
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := utils.StringToInt(v)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = n
+	}
+	jobs, err := h.Admin.ListDead(topic, limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, jobs)
+}
+
+// 重放死信: POST /dead/replay?topic=&ids=id1,id2
+func (h *Handler) replayDead(w http.ResponseWriter, r *http.Request) {
+	topic := r.URL.Query().Get("topic")
+	ids := r.URL.Query().Get("ids")
+	if topic == "" || ids == "" {
+		writeError(w, http.StatusBadRequest, "topic and ids are required")
+		return
+	}
+	if err := h.Admin.ReplayDead(topic, strings.Split(ids, ",")); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, map[string]bool{"replayed": true})
+}
+
+// 暂停中的Topic: GET /topics/held
+func (h *Handler) heldTopics(w http.ResponseWriter, r *http.Request) {
+	topics, err := h.Admin.HeldTopics()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, topics)
+}
+
+// 暂停Topic: POST /topics/hold?topic=
+func (h *Handler) holdTopic(w http.ResponseWriter, r *http.Request) {
+	topic := r.URL.Query().Get("topic")
+	if topic == "" {
+		writeError(w, http.StatusBadRequest, "topic is required")
+		return
+	}
+	if err := h.Admin.HoldTopic(topic); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, map[string]bool{"held": true})
+}
+
+// 恢复Topic: POST /topics/release?topic=
+func (h *Handler) releaseTopic(w http.ResponseWriter, r *http.Request) {
+	topic := r.URL.Query().Get("topic")
+	if topic == "" {
+		writeError(w, http.StatusBadRequest, "topic is required")
+		return
+	}
+	ok, err := h.Admin.ReleaseTopic(topic)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, "topic not held")
+		return
+	}
+	writeJSON(w, map[string]bool{"released": true})
+}
+
+// 限制 GET 请求
+func (h *Handler) get(fn http.HandlerFunc) http.HandlerFunc {
+	return method(http.MethodGet, fn)
+}
+
+// 限制 POST 请求
+func (h *Handler) post(fn http.HandlerFunc) http.HandlerFunc {
+	return method(http.MethodPost, fn)
+}
+
+// 限制请求方法
+func method(name string, fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != name {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		fn(w, r)
+	}
+}
+
+// 输出JSON
+func writeJSON(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
+
+// 输出错误
+func writeError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -0,0 +1,187 @@
+package httpadmin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dcsunny/delayer/logic"
+	"github.com/dcsunny/delayer/utils"
+)
+
+// 创建连接测试服务器的管理接口
+func newTestHandler(t *testing.T) (*Handler, *miniredis.Miniredis) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, err := logic.NewAdmin(utils.Config{
+		Redis: utils.Redis{
+			Host:        s.Host(),
+			Port:        s.Port(),
+			MaxIdle:     10,
+			DialTimeout: 1,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		admin.Pool.Close()
+		s.Close()
+	})
+	return NewHandler(admin), s
+}
+
+// 发送请求, 返回状态码并解析响应
+func request(t *testing.T, h *Handler, method, target string, v interface{}) int {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("%s %s: unexpected content type %q", method, target, ct)
+	}
+	if v != nil && w.Code == http.StatusOK {
+		if err := json.NewDecoder(w.Body).Decode(v); err != nil {
+			t.Fatalf("%s %s: %v", method, target, err)
+		}
+	}
+	return w.Code
+}
+
+func TestStatsAndTopics(t *testing.T) {
+	h, s := newTestHandler(t)
+	s.Lpush(logic.PREFIX_READY_QUEUE+"mail", "a")
+	s.Lpush(logic.PREFIX_READY_QUEUE+"mail", "b")
+	s.Lpush(logic.PREFIX_READY_QUEUE+"sms", "c")
+	var stats map[string]int64
+	if code := request(t, h, http.MethodGet, "/stats", &stats); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if !reflect.DeepEqual(stats, map[string]int64{"mail": 2, "sms": 1}) {
+		t.Fatalf("unexpected stats %v", stats)
+	}
+	var topics []string
+	if code := request(t, h, http.MethodGet, "/topics", &topics); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if !reflect.DeepEqual(topics, []string{"mail", "sms"}) {
+		t.Fatalf("unexpected topics %v", topics)
+	}
+	if code := request(t, h, http.MethodPost, "/stats", nil); code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405 for POST /stats, got %d", code)
+	}
+}
+
+func TestInspectAndCancel(t *testing.T) {
+	h, s := newTestHandler(t)
+	s.ZAdd(logic.KEY_JOB_POOL, 100, "a")
+	s.HSet(logic.PREFIX_JOB_BUCKET+"a", logic.FIELD_TOPIC, "mail")
+	var job logic.Job
+	if code := request(t, h, http.MethodGet, "/jobs?id=a", &job); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if job.ID != "a" || job.Topic != "mail" {
+		t.Fatalf("unexpected job %+v", job)
+	}
+	for _, c := range []struct {
+		method string
+		target string
+		code   int
+	}{
+		{http.MethodGet, "/jobs", http.StatusBadRequest},
+		{http.MethodGet, "/jobs?id=missing", http.StatusNotFound},
+		{http.MethodGet, "/jobs/cancel?id=a", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/jobs/cancel", http.StatusBadRequest},
+		{http.MethodPost, "/jobs/cancel?id=a", http.StatusOK},
+		{http.MethodPost, "/jobs/cancel?id=a", http.StatusNotFound},
+		{http.MethodGet, "/jobs?id=a", http.StatusNotFound},
+	} {
+		if code := request(t, h, c.method, c.target, nil); code != c.code {
+			t.Fatalf("%s %s: expected %d, got %d", c.method, c.target, c.code, code)
+		}
+	}
+	if members, _ := s.ZMembers(logic.KEY_JOB_POOL); len(members) != 0 {
+		t.Fatalf("expected the cancelled job removed from the pool, got %v", members)
+	}
+}
+
+func TestDeadLetter(t *testing.T) {
+	h, s := newTestHandler(t)
+	for _, jobID := range []string{"a", "b"} {
+		s.HSet(logic.PREFIX_JOB_BUCKET+jobID, logic.FIELD_TOPIC, "mail")
+		s.HSet(logic.PREFIX_JOB_BUCKET+jobID, logic.FIELD_RETRIES, "3")
+		s.Push(logic.PREFIX_DEAD_QUEUE+"mail", jobID)
+	}
+	var jobs []logic.Job
+	if code := request(t, h, http.MethodGet, "/dead?topic=mail&limit=1", &jobs); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if len(jobs) != 1 || jobs[0].ID != "a" {
+		t.Fatalf("expected only job a, got %+v", jobs)
+	}
+	for _, target := range []string{"/dead", "/dead?topic=mail&limit=0", "/dead?topic=mail&limit=x"} {
+		if code := request(t, h, http.MethodGet, target, nil); code != http.StatusBadRequest {
+			t.Fatalf("GET %s: expected 400, got %d", target, code)
+		}
+	}
+	if code := request(t, h, http.MethodPost, "/dead/replay?topic=mail", nil); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without ids, got %d", code)
+	}
+	if code := request(t, h, http.MethodPost, "/dead/replay?topic=mail&ids=a,b", nil); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if s.Exists(logic.PREFIX_DEAD_QUEUE + "mail") {
+		t.Fatal("expected the dead queue to be empty")
+	}
+	members, err := s.ZMembers(logic.KEY_JOB_POOL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(members, []string{"a", "b"}) {
+		t.Fatalf("expected replayed jobs in the pool, got %v", members)
+	}
+	if v := s.HGet(logic.PREFIX_JOB_BUCKET+"a", logic.FIELD_RETRIES); v != "0" {
+		t.Fatalf("expected the retry count reset, got %q", v)
+	}
+}
+
+func TestHoldAndRelease(t *testing.T) {
+	h, _ := newTestHandler(t)
+	if code := request(t, h, http.MethodPost, "/topics/hold", nil); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without topic, got %d", code)
+	}
+	if code := request(t, h, http.MethodPost, "/topics/hold?topic=mail", nil); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	var topics []string
+	if code := request(t, h, http.MethodGet, "/topics/held", &topics); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if !reflect.DeepEqual(topics, []string{"mail"}) {
+		t.Fatalf("expected [mail] to be held, got %v", topics)
+	}
+	if code := request(t, h, http.MethodPost, "/topics/release?topic=mail", nil); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if code := request(t, h, http.MethodPost, "/topics/release?topic=mail", nil); code != http.StatusNotFound {
+		t.Fatalf("expected 404 for a topic not held, got %d", code)
+	}
+}
+
+func TestAdminError(t *testing.T) {
+	h, s := newTestHandler(t)
+	s.Close()
+	var body map[string]string
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500 when redis is down, got %d", w.Code)
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil || body["error"] == "" {
+		t.Fatalf("expected an error message, got %v (%v)", body, err)
+	}
+}
@@ -0,0 +1,511 @@
+package logic
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dcsunny/delayer/utils"
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	REPLAY_DELAY    = 5 * time.Second
+	SCAN_BATCH_SIZE = 100
+)
+
+// 管理类
+type Admin struct {
+	Pool *redis.Pool
+	// 命名空间, 需与定时器一致
+	Namespace string
+	// 分组字段, 需与定时器一致, 为空时使用 topic
+	GroupField string
+	// ReadyQueue顺序, 需与定时器一致
+	ReadyOrder string
+	// 兼容模式, 需与定时器一致, 开启后不使用 EVAL
+	Compat bool
+	// Topic缓存有效期, 为 0 时每次调用都重新扫描
+	TopicsCacheTTL time.Duration
+	topicsMutex    sync.RWMutex
+	topics         map[string]int64
+	topicsTime     time.Time
+}
+
+// 创建实例, 使用独立的连接池
+func NewAdmin(config utils.Config) (*Admin, error) {
+	password, err := readPassword(config)
+	if err != nil {
+		return nil, err
+	}
+	admin := &Admin{
+		Pool:       newPool(config, password, ROLE_ADMIN, nil),
+		Namespace:  config.Delayer.Namespace,
+		GroupField: config.Delayer.GroupField,
+		ReadyOrder: config.Delayer.ReadyOrder,
+		Compat:     config.Redis.Compat,
+	}
+	return admin, nil
+}
+
+// 键名
+func (p *Admin) keys() Keys {
+	return NewKeys(p.Namespace)
+}
+
+// 分组字段, 默认为 topic
+func (p *Admin) groupField() string {
+	if p.GroupField == "" {
+		return FIELD_TOPIC
+	}
+	return p.GroupField
+}
+
+// 统计指定时间窗口内到期的任务数, 包括RetryPool与暂停Topic中暂存的任务, 不含隔离的任务
+func (p *Admin) CountDueWithin(d time.Duration) (int64, error) {
+	return p.countPending(utils.Int64ToString(time.Now().Add(d).Unix()), false)
+}
+
+// 统计已过期但尚未移动的任务数, 包括暂存的任务, RetryPool中的任务暂存前均已过期
+func (p *Admin) CountOverdue() (int64, error) {
+	return p.countPending("("+utils.Int64ToString(time.Now().Unix()), true)
+}
+
+// 统计各等待集合中分数不超过 max 的任务数, allRetry 时计入RetryPool中的全部任务
+func (p *Admin) countPending(max string, allRetry bool) (int64, error) {
+	conn := p.Pool.Get()
+	defer conn.Close()
+	sets, err := p.pendingSets(conn)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, set := range sets {
+		if set.Source == SOURCE_QUARANTINE {
+			continue
+		}
+		var n int64
+		if set.Source == SOURCE_RETRY_POOL && allRetry {
+			n, err = redis.Int64(conn.Do("ZCARD", set.Key))
+		} else {
+			n, err = redis.Int64(conn.Do("ZCOUNT", set.Key, "0", max))
+		}
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// 获取全部Topic
+func (p *Admin) Topics() ([]string, error) {
+	stats, err := p.Stats()
+	if err != nil {
+		return nil, err
+	}
+	topics := make([]string, 0, len(stats))
+	for topic := range stats {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics, nil
+}
+
+// 获取各Topic的ReadyQueue长度
+func (p *Admin) Stats() (map[string]int64, error) {
+	p.topicsMutex.RLock()
+	if p.topics != nil && time.Since(p.topicsTime) < p.TopicsCacheTTL {
+		stats := copyStats(p.topics)
+		p.topicsMutex.RUnlock()
+		return stats, nil
+	}
+	p.topicsMutex.RUnlock()
+	if err := p.Refresh(); err != nil {
+		return nil, err
+	}
+	p.topicsMutex.RLock()
+	defer p.topicsMutex.RUnlock()
+	return copyStats(p.topics), nil
+}
+
+// 强制刷新Topic缓存
+func (p *Admin) Refresh() error {
+	stats, err := p.scanTopics()
+	if err != nil {
+		return err
+	}
+	p.topicsMutex.Lock()
+	p.topics = stats
+	p.topicsTime = time.Now()
+	p.topicsMutex.Unlock()
+	return nil
+}
+
+// 扫描ReadyQueue
+func (p *Admin) scanTopics() (map[string]int64, error) {
+	conn := p.Pool.Get()
+	defer conn.Close()
+	stats := make(map[string]int64)
+	cursor := "0"
+	for {
+		values, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", p.keys().ReadyQueue+"*", "COUNT", SCAN_BATCH_SIZE))
+		if err != nil {
+			return nil, err
+		}
+		cursor, _ = redis.String(values[0], nil)
+		keys, _ := redis.Strings(values[1], nil)
+		for _, key := range keys {
+			length, err := redis.Int64(conn.Do("LLEN", key))
+			if err != nil {
+				return nil, err
+			}
+			stats[strings.TrimPrefix(key, p.keys().ReadyQueue)] = length
+		}
+		if cursor == "0" {
+			return stats, nil
+		}
+	}
+}
+
+// 复制统计数据
+func copyStats(stats map[string]int64) map[string]int64 {
+	data := make(map[string]int64, len(stats))
+	for k, v := range stats {
+		data[k] = v
+	}
+	return data
+}
+
+// 获取死信队列中的任务
+func (p *Admin) ListDead(topic string, limit int) ([]*Job, error) {
+	conn := p.Pool.Get()
+	defer conn.Close()
+	jobIDs, err := redis.Strings(conn.Do("LRANGE", p.keys().DeadQueue+topic, 0, limit-1))
+	if err != nil {
+		return nil, err
+	}
+	var jobs []*Job
+	for _, jobID := range jobIDs {
+		fields, err := redis.StringMap(conn.Do("HGETALL", p.keys().JobBucket+jobID))
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		jobs = append(jobs, newJob(jobID, fields))
+	}
+	return jobs, nil
+}
+
+// 重放死信任务, 重置重试次数后延迟放回JobPool
+func (p *Admin) ReplayDead(topic string, ids []string) error {
+	conn := p.Pool.Get()
+	defer conn.Close()
+	for _, jobID := range ids {
+		n, err := redis.Int64(conn.Do("LREM", p.keys().DeadQueue+topic, 0, jobID))
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			continue
+		}
+		conn.Send("MULTI")
+		conn.Send("HMSET", p.keys().JobBucket+jobID, FIELD_RETRIES, 0, FIELD_VERSION, BUCKET_VERSION)
+		conn.Send("ZADD", p.keys().JobPool, time.Now().Add(REPLAY_DELAY).Unix(), jobID)
+		if _, err := conn.Do("EXEC"); err != nil {
+			// 放回死信队列
+			conn.Do("RPUSH", p.keys().DeadQueue+topic, jobID)
+			return err
+		}
+	}
+	return nil
+}
+
+// 按就绪时间分页获取Topic中等待的任务, 包括RetryPool与暂停时暂存的任务, Topic按分组字段匹配
+// RetryPool中的任务按重试时间排序, 分组字段与任务数据均按批流水线读取
+func (p *Admin) ListPending(topic string, offset, limit int) ([]*Job, error) {
+	conn := p.Pool.Get()
+	defer conn.Close()
+	keys := p.keys()
+	merger := newPendingMerger(conn, keys.JobPool, keys.RetryPool, keys.HeldPool+topic)
+	var jobs []*Job
+	matched := 0
+	now := time.Now()
+	for len(jobs) < limit {
+		batch, err := merger.next(SCAN_BATCH_SIZE)
+		if err != nil {
+			return nil, err
+		}
+		if len(batch) == 0 {
+			break
+		}
+		// 先读取分组字段, 仅读取本页任务的全部字段
+		for _, job := range batch {
+			conn.Send("HGET", keys.JobBucket+job.ID, p.groupField())
+		}
+		if err := conn.Flush(); err != nil {
+			return nil, err
+		}
+		var page []scoredJob
+		for _, job := range batch {
+			t, err := redis.String(conn.Receive())
+			if err != nil && err != redis.ErrNil {
+				return nil, err
+			}
+			if t != topic {
+				continue
+			}
+			matched++
+			if matched > offset && len(jobs)+len(page) < limit {
+				page = append(page, job)
+			}
+		}
+		for _, job := range page {
+			conn.Send("HGETALL", keys.JobBucket+job.ID)
+		}
+		if err := conn.Flush(); err != nil {
+			return nil, err
+		}
+		for _, item := range page {
+			fields, err := redis.StringMap(conn.Receive())
+			if err != nil {
+				return nil, err
+			}
+			// 读取期间已被移动或取消
+			if len(fields) == 0 {
+				continue
+			}
+			job := newJob(item.ID, fields)
+			job.Delay = time.Unix(item.Score, 0).Sub(now)
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, nil
+}
+
+// 获取死信队列长度
+func (p *Admin) DeadLen(topic string) (int64, error) {
+	conn := p.Pool.Get()
+	defer conn.Close()
+	return redis.Int64(conn.Do("LLEN", p.keys().DeadQueue+topic))
+}
+
+// 查看任务, 任务不存在时返回 nil
+func (p *Admin) Inspect(jobID string) (*Job, error) {
+	conn := p.Pool.Get()
+	defer conn.Close()
+	fields, err := redis.StringMap(conn.Do("HGETALL", p.keys().JobBucket+jobID))
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	job := newJob(jobID, fields)
+	score, err := scoreInt64(conn.Do("ZSCORE", p.keys().JobPool, jobID))
+	if err != nil && err != redis.ErrNil {
+		return nil, err
+	}
+	if err == nil {
+		job.Delay = time.Until(time.Unix(score, 0))
+	}
+	return job, nil
+}
+
+// 取消任务, 同时移出RetryPool, 暂存集合与隔离集合, 返回任务是否存在
+func (p *Admin) Cancel(jobID string) (bool, error) {
+	conn := p.Pool.Get()
+	defer conn.Close()
+	keys := p.keys()
+	// 暂存集合按分组字段区分, 需在删除 bucket 前读取
+	topic, err := redis.String(conn.Do("HGET", keys.JobBucket+jobID, p.groupField()))
+	if err != nil && err != redis.ErrNil {
+		return false, err
+	}
+	conn.Send("MULTI")
+	conn.Send("ZREM", keys.JobPool, jobID)
+	conn.Send("ZREM", keys.RetryPool, jobID)
+	conn.Send("ZREM", keys.Quarantine, jobID)
+	if topic != "" {
+		conn.Send("ZREM", keys.HeldPool+topic, jobID)
+	}
+	conn.Send("DEL", keys.JobBucket+jobID)
+	values, err := redis.Int64s(conn.Do("EXEC"))
+	if err != nil {
+		return false, err
+	}
+	for _, n := range values {
+		if n > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// 按 bucket 中的基准时间与偏移量重新计算就绪时间, 返回更新的任务数
+func (p *Admin) RecomputeScores() (int64, error) {
+	conn := p.Pool.Get()
+	defer conn.Close()
+	var updated int64
+	cursor := "0"
+	for {
+		values, err := redis.Values(conn.Do("ZSCAN", p.keys().JobPool, cursor, "COUNT", SCAN_BATCH_SIZE))
+		if err != nil {
+			return updated, err
+		}
+		cursor, _ = redis.String(values[0], nil)
+		scores, _ := scoreMap(values[1], nil)
+		for jobID, score := range scores {
+			fields, err := redis.Strings(conn.Do("HMGET", p.keys().JobBucket+jobID, FIELD_BASE, FIELD_OFFSET))
+			if err != nil {
+				return updated, err
+			}
+			base, err := utils.StringToInt64(fields[0])
+			if err != nil {
+				continue
+			}
+			offset, err := utils.StringToInt64(fields[1])
+			if err != nil {
+				continue
+			}
+			if base+offset == score {
+				continue
+			}
+			n, err := redis.Int64(conn.Do("ZADD", p.keys().JobPool, "XX", "CH", base+offset, jobID))
+			if err != nil {
+				return updated, err
+			}
+			updated += n
+		}
+		if cursor == "0" {
+			return updated, nil
+		}
+	}
+}
+
+// 推迟一批任务, 在脚本中读取分数与 bucket 的分组字段, 避免与定时器移动或其他推迟交错
+// KEYS[1] 有序集合, KEYS[2..] 各任务的 bucket; ARGV[1] 分组字段, ARGV[2] Topic, ARGV[3] 推迟秒数, ARGV[4..] 任务ID
+var delayJobsScript = redis.NewScript(-1, `
+local n = 0
+for i = 4, #ARGV do
+	local score = redis.call('ZSCORE', KEYS[1], ARGV[i])
+	if score and redis.call('HGET', KEYS[i - 2], ARGV[1]) == ARGV[2] then
+		redis.call('ZADD', KEYS[1], tonumber(score) + tonumber(ARGV[3]), ARGV[i])
+		n = n + 1
+	end
+end
+return n
+`)
+
+// 将Topic中所有等待的任务推迟指定时间, 包括RetryPool与暂停时暂存的任务, Topic按分组字段匹配, 返回推迟的任务数
+// 就绪时间以秒为单位, by 需为整秒, 兼容模式下不使用 EVAL, 以 ZADD XX INCR 推迟
+func (p *Admin) DelayTopic(topic string, by time.Duration) (int64, error) {
+	if by%time.Second != 0 {
+		return 0, fmt.Errorf("delay %s is not a whole number of seconds", by)
+	}
+	if by == 0 {
+		return 0, nil
+	}
+	conn := p.Pool.Get()
+	defer conn.Close()
+	keys := p.keys()
+	var count int64
+	for _, key := range []string{keys.JobPool, keys.RetryPool, keys.HeldPool + topic} {
+		n, err := p.delaySet(conn, key, topic, int64(by/time.Second))
+		count += n
+		if err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+// 推迟有序集合中属于Topic的任务
+func (p *Admin) delaySet(conn redis.Conn, key string, topic string, seconds int64) (int64, error) {
+	// 先收集再更新, 避免 ZSCAN 重复返回导致重复推迟
+	var jobIDs []string
+	seen := make(map[string]bool)
+	cursor := "0"
+	for {
+		values, err := redis.Values(conn.Do("ZSCAN", key, cursor, "COUNT", SCAN_BATCH_SIZE))
+		if err != nil {
+			return 0, err
+		}
+		cursor, _ = redis.String(values[0], nil)
+		batch, _ := scoreMap(values[1], nil)
+		for jobID := range batch {
+			if !seen[jobID] {
+				seen[jobID] = true
+				jobIDs = append(jobIDs, jobID)
+			}
+		}
+		if cursor == "0" {
+			break
+		}
+	}
+	var count int64
+	for start := 0; start < len(jobIDs); start += SCAN_BATCH_SIZE {
+		end := start + SCAN_BATCH_SIZE
+		if end > len(jobIDs) {
+			end = len(jobIDs)
+		}
+		var n int64
+		var err error
+		if p.Compat {
+			n, err = p.delayJobsCompat(conn, key, topic, seconds, jobIDs[start:end])
+		} else {
+			n, err = p.delayJobs(conn, key, topic, seconds, jobIDs[start:end])
+		}
+		count += n
+		if err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+// 使用脚本推迟一批任务
+func (p *Admin) delayJobs(conn redis.Conn, key string, topic string, seconds int64, jobIDs []string) (int64, error) {
+	args := redis.Args{}.Add(len(jobIDs)+1, key)
+	for _, jobID := range jobIDs {
+		args = args.Add(p.keys().JobBucket + jobID)
+	}
+	args = args.Add(p.groupField(), topic, seconds).AddFlat(jobIDs)
+	return redis.Int64(delayJobsScript.Do(conn, args...))
+}
+
+// 兼容模式下推迟一批任务, ZADD XX INCR 在原分数上增加, 不会覆盖并发的修改, 已移出集合的任务不会被重新加入
+func (p *Admin) delayJobsCompat(conn redis.Conn, key string, topic string, seconds int64, jobIDs []string) (int64, error) {
+	var matched []string
+	for _, jobID := range jobIDs {
+		t, err := redis.String(conn.Do("HGET", p.keys().JobBucket+jobID, p.groupField()))
+		if err != nil && err != redis.ErrNil {
+			return 0, err
+		}
+		if t == topic {
+			matched = append(matched, jobID)
+		}
+	}
+	for _, jobID := range matched {
+		conn.Send("ZADD", key, "XX", "INCR", seconds, jobID)
+	}
+	if err := conn.Flush(); err != nil {
+		return 0, err
+	}
+	var count int64
+	for range matched {
+		_, err := redis.Float64(conn.Receive())
+		if err == redis.ErrNil {
+			continue
+		}
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
@@ -0,0 +1,282 @@
+package logic
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 创建连接测试服务器的管理类
+func newTestAdmin(t *testing.T, s *fakeRedis) *Admin {
+	admin, err := NewAdmin(s.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		admin.Pool.Close()
+	})
+	return admin
+}
+
+func TestCountDueWithin(t *testing.T) {
+	s := newFakeRedis(t)
+	now := time.Now().Unix()
+	s.addJob("overdue", "mail", now-10)
+	s.addJob("minute", "mail", now+30)
+	s.addJob("hour", "mail", now+1800)
+	s.addJob("day", "mail", now+43200)
+	admin := newTestAdmin(t, s)
+	for _, c := range []struct {
+		window   time.Duration
+		expected int64
+	}{
+		{0, 1},
+		{time.Minute, 2},
+		{time.Hour, 3},
+		{24 * time.Hour, 4},
+	} {
+		n, err := admin.CountDueWithin(c.window)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != c.expected {
+			t.Errorf("CountDueWithin(%s): expected %d, got %d", c.window, c.expected, n)
+		}
+	}
+}
+
+func TestCountOverdue(t *testing.T) {
+	s := newFakeRedis(t)
+	now := time.Now().Unix()
+	s.addJob("a", "mail", now-60)
+	s.addJob("b", "mail", now-5)
+	s.addJob("c", "mail", now+60)
+	admin := newTestAdmin(t, s)
+	n, err := admin.CountOverdue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("expected 2 overdue jobs, got %d", n)
+	}
+}
+
+func TestDelayTopic(t *testing.T) {
+	for _, compat := range []bool{false, true} {
+		compat := compat
+		t.Run("compat="+strconv.FormatBool(compat), func(t *testing.T) {
+			s := newFakeRedis(t)
+			s.addJob("a", "mail", 100)
+			s.addJob("b", "mail", 200)
+			s.addJob("c", "sms", 100)
+			admin := newTestAdmin(t, s)
+			admin.Compat = compat
+			n, err := admin.DelayTopic("mail", time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != 2 {
+				t.Fatalf("expected 2 delayed jobs, got %d", n)
+			}
+			for jobID, expected := range map[string]float64{"a": 160, "b": 260, "c": 100} {
+				if score, _ := s.score(KEY_JOB_POOL, jobID); score != expected {
+					t.Errorf("job %s: expected score %v, got %v", jobID, expected, score)
+				}
+			}
+			if compat && s.count("EVAL") != 0 {
+				t.Fatal("compat mode should not use EVAL")
+			}
+		})
+	}
+}
+
+func TestDelayTopicRejectsSubSecond(t *testing.T) {
+	s := newFakeRedis(t)
+	s.addJob("a", "mail", 100)
+	admin := newTestAdmin(t, s)
+	for _, by := range []time.Duration{500 * time.Millisecond, 1500 * time.Millisecond} {
+		if _, err := admin.DelayTopic("mail", by); err == nil {
+			t.Fatalf("expected an error for %s", by)
+		}
+	}
+	if score, _ := s.score(KEY_JOB_POOL, "a"); score != 100 {
+		t.Fatalf("expected the score to be unchanged, got %v", score)
+	}
+}
+
+func TestDelayTopicSkipsMovedJobs(t *testing.T) {
+	for _, compat := range []bool{false, true} {
+		compat := compat
+		t.Run("compat="+strconv.FormatBool(compat), func(t *testing.T) {
+			s := newFakeRedis(t)
+			s.addJob("a", "mail", 100)
+			s.addJob("b", "mail", 100)
+			// 收集后, 推迟前任务 a 已被定时器移出JobPool
+			var once sync.Once
+			s.setHook(func(cmd string, args []string) error {
+				if cmd == "EVAL" || (cmd == "HGET" && compat) {
+					once.Do(func() {
+						s.do("ZREM", KEY_JOB_POOL, "a")
+					})
+				}
+				return nil
+			})
+			admin := newTestAdmin(t, s)
+			admin.Compat = compat
+			n, err := admin.DelayTopic("mail", time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != 1 {
+				t.Fatalf("expected 1 delayed job, got %d", n)
+			}
+			if _, ok := s.score(KEY_JOB_POOL, "a"); ok {
+				t.Fatal("moved job was added back to the pool")
+			}
+		})
+	}
+}
+
+func TestListPendingPaging(t *testing.T) {
+	s := newFakeRedis(t)
+	// 250 个 mail 任务与 sms 任务交错, 跨越多个读取批次
+	for i := 0; i < 250; i++ {
+		s.addJob(fmt.Sprintf("mail-%03d", i), "mail", int64(1000+2*i))
+		s.addJob(fmt.Sprintf("sms-%03d", i), "sms", int64(1001+2*i))
+	}
+	admin := newTestAdmin(t, s)
+	for _, c := range []struct {
+		offset, limit int
+		first, last   int
+		n             int
+	}{
+		{0, 100, 0, 99, 100},
+		{100, 100, 100, 199, 100},
+		{200, 100, 200, 249, 50},
+		{99, 2, 99, 100, 2},
+		{249, 10, 249, 249, 1},
+		{250, 10, 0, 0, 0},
+		{0, 0, 0, 0, 0},
+	} {
+		jobs, err := admin.ListPending("mail", c.offset, c.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(jobs) != c.n {
+			t.Fatalf("offset %d limit %d: expected %d jobs, got %d", c.offset, c.limit, c.n, len(jobs))
+		}
+		if c.n == 0 {
+			continue
+		}
+		if first, last := fmt.Sprintf("mail-%03d", c.first), fmt.Sprintf("mail-%03d", c.last); jobs[0].ID != first || jobs[len(jobs)-1].ID != last {
+			t.Fatalf("offset %d limit %d: expected %s..%s, got %s..%s", c.offset, c.limit, first, last, jobs[0].ID, jobs[len(jobs)-1].ID)
+		}
+	}
+}
+
+func TestListPendingReadsOnlyPage(t *testing.T) {
+	s := newFakeRedis(t)
+	for i := 0; i < 50; i++ {
+		s.addJob(fmt.Sprintf("mail-%03d", i), "mail", int64(1000+i))
+	}
+	admin := newTestAdmin(t, s)
+	jobs, err := admin.ListPending("mail", 20, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 5 || jobs[0].ID != "mail-020" {
+		t.Fatalf("unexpected page %v", jobs)
+	}
+	if n := s.count("HGETALL"); n != 5 {
+		t.Fatalf("expected only the page to be read in full, got %d HGETALL", n)
+	}
+}
+
+func TestStatsCachedWithinTTL(t *testing.T) {
+	s := newFakeRedis(t)
+	s.do("LPUSH", PREFIX_READY_QUEUE+"mail", "a")
+	admin := newTestAdmin(t, s)
+	admin.TopicsCacheTTL = time.Minute
+	stats, err := admin.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats["mail"] != 1 {
+		t.Fatalf("expected mail length 1, got %v", stats)
+	}
+	// 有效期内不重新扫描, 并发读取安全
+	s.do("LPUSH", PREFIX_READY_QUEUE+"sms", "b")
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if topics, err := admin.Topics(); err != nil || len(topics) != 1 {
+				t.Errorf("expected cached topics, got %v (%v)", topics, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := s.count("SCAN"); n != 1 {
+		t.Fatalf("expected a single scan, got %d", n)
+	}
+	// 强制刷新
+	if err := admin.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	topics, err := admin.Topics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(topics) != 2 {
+		t.Fatalf("expected refreshed topics, got %v", topics)
+	}
+}
+
+func TestStatsWithoutTTL(t *testing.T) {
+	s := newFakeRedis(t)
+	admin := newTestAdmin(t, s)
+	admin.Stats()
+	admin.Stats()
+	if n := s.count("SCAN"); n != 2 {
+		t.Fatalf("expected a scan per call without a TTL, got %d", n)
+	}
+}
+
+func TestRecomputeScores(t *testing.T) {
+	s := newFakeRedis(t)
+	s.addJob("current", "mail", 1000+86400)
+	s.do("HSET", PREFIX_JOB_BUCKET+"current", FIELD_BASE, "1000", FIELD_OFFSET, "86400")
+	s.addJob("stale", "mail", 500)
+	s.do("HSET", PREFIX_JOB_BUCKET+"stale", FIELD_BASE, "1000", FIELD_OFFSET, "3600")
+	// 未记录基准时间或字段无效的任务不变
+	s.addJob("absolute", "mail", 300)
+	s.addJob("invalid", "mail", 400)
+	s.do("HSET", PREFIX_JOB_BUCKET+"invalid", FIELD_BASE, "x", FIELD_OFFSET, "60")
+	admin := newTestAdmin(t, s)
+	n, err := admin.RecomputeScores()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("expected 1 updated job, got %d", n)
+	}
+	for jobID, expected := range map[string]int64{"current": 87400, "stale": 4600, "absolute": 300, "invalid": 400} {
+		if score, _ := s.score(KEY_JOB_POOL, jobID); int64(score) != expected {
+			t.Errorf("job %s: expected score %d, got %d", jobID, expected, int64(score))
+		}
+	}
+	// 调整偏移量规则后重新计算
+	s.do("HSET", PREFIX_JOB_BUCKET+"current", FIELD_OFFSET, strconv.Itoa(2*86400))
+	if n, err := admin.RecomputeScores(); err != nil || n != 1 {
+		t.Fatalf("expected 1 updated job after changing the offset, got %d (%v)", n, err)
+	}
+	if score, _ := s.score(KEY_JOB_POOL, "current"); int64(score) != 1000+2*86400 {
+		t.Fatalf("expected the fire time moved with the offset, got %d", int64(score))
+	}
+	if n, err := admin.RecomputeScores(); err != nil || n != 0 {
+		t.Fatalf("expected no updates once scores match, got %d (%v)", n, err)
+	}
+}
@@ -0,0 +1,56 @@
+package logic
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	MAX_MOVE_BACKOFF = time.Minute
+)
+
+// Topic移动失败退避
+type topicBackoff struct {
+	mutex    sync.Mutex
+	failures map[string]uint
+	until    map[string]time.Time
+}
+
+// 是否允许移动
+func (p *topicBackoff) allow(topic string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return !time.Now().Before(p.until[topic])
+}
+
+// 退避结束时间
+func (p *topicBackoff) resume(topic string) time.Time {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.until[topic]
+}
+
+// 记录移动结果, 连续失败时按 base 指数退避
+func (p *topicBackoff) done(topic string, ok bool, base time.Duration) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.failures == nil {
+		p.failures = make(map[string]uint)
+		p.until = make(map[string]time.Time)
+	}
+	if ok {
+		delete(p.failures, topic)
+		delete(p.until, topic)
+		return
+	}
+	p.failures[topic]++
+	failures := p.failures[topic]
+	if failures > 16 {
+		failures = 16
+	}
+	wait := base << (failures - 1)
+	if wait <= 0 || wait > MAX_MOVE_BACKOFF {
+		wait = MAX_MOVE_BACKOFF
+	}
+	p.until[topic] = time.Now().Add(wait)
+}
@@ -0,0 +1,66 @@
+package logic
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 使写入指定ReadyQueue的连接断开
+func failQueue(s *fakeRedis, topic string, attempts *int32) {
+	s.setHook(func(cmd string, args []string) error {
+		if (cmd == "LPUSH" || cmd == "RPUSH") && args[0] == PREFIX_READY_QUEUE+topic {
+			atomic.AddInt32(attempts, 1)
+			return errDrop
+		}
+		return nil
+	})
+}
+
+func TestFailingTopicBacksOff(t *testing.T) {
+	s := newFakeRedis(t)
+	now := time.Now().Unix()
+	s.addJob("bad-1", "bad", now-1)
+	s.addJob("good-1", "good", now-1)
+	var attempts int32
+	failQueue(s, "bad", &attempts)
+	timer := newTestTimer(t, s, nil)
+	timer.tick()
+	assertQueue(t, s, "good", "good-1")
+	assertPending(t, s, "bad-1")
+	if n := atomic.LoadInt32(&attempts); n != 1 {
+		t.Fatalf("expected 1 attempt, got %d", n)
+	}
+	// 退避期间不再尝试, 任务暂存至RetryPool, 其他Topic不受影响
+	s.addJob("good-2", "good", now-1)
+	timer.tick()
+	assertQueue(t, s, "good", "good-1", "good-2")
+	if n := atomic.LoadInt32(&attempts); n != 1 {
+		t.Fatalf("expected no attempt during backoff, got %d", n)
+	}
+	if _, ok := s.score(KEY_RETRY_POOL, "bad-1"); !ok {
+		t.Fatal("backed-off job is not parked in the retry pool")
+	}
+	if _, ok := s.score(KEY_JOB_POOL, "bad-1"); ok {
+		t.Fatal("backed-off job is still in the pool")
+	}
+}
+
+func TestBackoffGrowsAndResets(t *testing.T) {
+	var backoff topicBackoff
+	base := 10 * time.Millisecond
+	backoff.done("bad", false, base)
+	first := time.Until(backoff.resume("bad"))
+	backoff.done("bad", false, base)
+	second := time.Until(backoff.resume("bad"))
+	if second <= first {
+		t.Fatalf("expected backoff to grow, got %s then %s", first, second)
+	}
+	if backoff.allow("bad") {
+		t.Fatal("expected topic to be backed off")
+	}
+	backoff.done("bad", true, base)
+	if !backoff.allow("bad") {
+		t.Fatal("expected backoff to reset after success")
+	}
+}
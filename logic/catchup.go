@@ -0,0 +1,57 @@
+package logic
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// 追赶积压, 首次启动时过期任务超过阈值则分批移动, 批次间暂停以免压垮 Redis 与消费者
+func (p *Timer) catchUp(stop chan bool) {
+	threshold := p.Config.Delayer.CatchUpThreshold
+	if threshold <= 0 || p.caughtUp {
+		return
+	}
+	p.caughtUp = true
+	pause := time.Duration(p.Config.Delayer.CatchUpPause) * time.Millisecond
+	p.catchUpLimit = p.Config.Delayer.CatchUpBatchSize
+	defer func() {
+		p.catchUpLimit = 0
+	}()
+	var last int64 = -1
+	for batches := 0; ; batches++ {
+		if p.isStopping() {
+			return
+		}
+		overdue, err := p.countOverdue()
+		if err != nil {
+			p.handleError(err, "catchUp", "")
+			return
+		}
+		// 积压未减少时交回常规执行, 避免无法移动的任务导致一直追赶
+		if overdue <= int64(threshold) || overdue == last {
+			if batches > 0 {
+				p.Logger.Info(fmt.Sprintf("Catch-up finished, batches: %d", batches))
+			}
+			return
+		}
+		if batches == 0 {
+			p.Logger.Info(fmt.Sprintf("Catch-up started, overdue jobs: %d", overdue))
+		}
+		last = overdue
+		p.tick()
+		select {
+		case <-stop:
+			return
+		case <-time.After(pause):
+		}
+	}
+}
+
+// 统计过期任务数
+func (p *Timer) countOverdue() (int64, error) {
+	conn := p.pool().Get()
+	defer conn.Close()
+	return redis.Int64(conn.Do("ZCOUNT", p.keys.JobPool, "0", p.now(conn)))
+}
@@ -0,0 +1,55 @@
+package logic
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dcsunny/delayer/utils"
+)
+
+func TestCatchUpPacedBatches(t *testing.T) {
+	s := newFakeRedis(t)
+	now := time.Now().Unix()
+	for i := 0; i < 25; i++ {
+		s.addJob("job"+strconv.Itoa(i), "mail", now-100+int64(i))
+	}
+	var mutex sync.Mutex
+	var scanned []time.Time
+	s.setHook(func(cmd string, args []string) error {
+		// 仅记录获取到期任务的扫描, 不含隔离检查
+		if cmd == "ZRANGEBYSCORE" && args[0] == KEY_JOB_POOL && args[1] == "0" {
+			mutex.Lock()
+			scanned = append(scanned, time.Now())
+			mutex.Unlock()
+		}
+		return nil
+	})
+	timer := newTestTimer(t, s, func(config *utils.Config) {
+		// 常规执行不在测试期间触发
+		config.Delayer.TimerInterval = 60000
+		config.Delayer.CatchUpThreshold = 10
+		config.Delayer.CatchUpBatchSize = 5
+		config.Delayer.CatchUpPause = 30
+	})
+	timer.Start()
+	defer timer.Stop()
+	waitFor(t, 2*time.Second, "catch-up did not finish", func() bool {
+		return timer.Logger.(*testLogger).contains("Catch-up finished, batches: 3")
+	})
+	// 积压降至阈值后交回常规执行
+	if n := len(s.queue("mail")); n != 15 {
+		t.Fatalf("expected 3 batches of 5 jobs, got %d moved", n)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(scanned) != 3 {
+		t.Fatalf("expected 3 paced scans, got %d", len(scanned))
+	}
+	for i := 1; i < len(scanned); i++ {
+		if gap := scanned[i].Sub(scanned[i-1]); gap < 30*time.Millisecond {
+			t.Fatalf("expected at least 30ms between batches, got %s", gap)
+		}
+	}
+}
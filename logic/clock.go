@@ -0,0 +1,68 @@
+package logic
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	// 本地时钟与Redis时钟偏差的告警阈值
+	MAX_CLOCK_SKEW = 2 * time.Second
+)
+
+// 本地时钟相对Redis时钟的偏差, 正值表示本地时钟较快
+func (p *Admin) ClockSkew() (time.Duration, error) {
+	conn := p.Pool.Get()
+	defer conn.Close()
+	return clockSkew(conn)
+}
+
+// 对比本地时间与Redis TIME, 以往返时间的中点作为本地时间
+func clockSkew(conn redis.Conn) (time.Duration, error) {
+	start := time.Now()
+	values, err := redis.Int64s(conn.Do("TIME"))
+	if err != nil {
+		return 0, err
+	}
+	if len(values) != 2 {
+		return 0, fmt.Errorf("unexpected TIME reply: %v", values)
+	}
+	local := start.Add(time.Since(start) / 2)
+	server := time.Unix(values[0], values[1]*int64(time.Microsecond))
+	return local.Sub(server), nil
+}
+
+// 检查时钟偏差, 定时器依赖本地时间判断任务到期, 偏差过大时告警
+func (p *Timer) checkClockSkew() {
+	conn := p.pool().Get()
+	defer conn.Close()
+	skew, err := clockSkew(conn)
+	if err != nil {
+		p.handleError(err, "checkClockSkew", "")
+		return
+	}
+	if skew > MAX_CLOCK_SKEW || skew < -MAX_CLOCK_SKEW {
+		p.Logger.Warn(fmt.Sprintf("Clock skew between local and Redis exceeds %s, skew: %s", MAX_CLOCK_SKEW, skew))
+	}
+}
+
+// 按Redis服务器时间获取到期任务, 避免各实例本地时钟不一致
+var expireJobsScript = redis.NewScript(1, `
+local now = redis.call('TIME')
+return redis.call('ZRANGEBYSCORE', KEYS[1], '0', now[1], 'WITHSCORES', 'LIMIT', 0, ARGV[1])
+`)
+
+// 当前时间, 启用 server_time 时使用Redis服务器时间, 获取失败时使用本地时间
+func (p *Timer) now(conn redis.Conn) int64 {
+	if !p.Config.Delayer.ServerTime {
+		return time.Now().Unix()
+	}
+	values, err := redis.Int64s(conn.Do("TIME"))
+	if err != nil || len(values) != 2 {
+		p.fail(err, "now", "")
+		return time.Now().Unix()
+	}
+	return values[0]
+}
@@ -0,0 +1,58 @@
+package logic
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dcsunny/delayer/utils"
+)
+
+func TestClockSkew(t *testing.T) {
+	s := newFakeRedis(t)
+	s.update(func() {
+		s.offset = -10 * time.Second
+	})
+	admin := newTestAdmin(t, s)
+	skew, err := admin.ClockSkew()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 本地时钟较快时为正值
+	if skew < 9*time.Second || skew > 11*time.Second {
+		t.Fatalf("expected a skew of about 10s, got %s", skew)
+	}
+	timer := newTestTimer(t, s, nil)
+	if !timer.Logger.(*testLogger).contains("Clock skew") {
+		t.Fatal("expected a clock skew warning at startup")
+	}
+}
+
+func TestClockSkewWithinThreshold(t *testing.T) {
+	s := newFakeRedis(t)
+	timer := newTestTimer(t, s, nil)
+	if timer.Logger.(*testLogger).contains("Clock skew") {
+		t.Fatal("unexpected clock skew warning")
+	}
+}
+
+func TestServerTimeUsedForDueJobs(t *testing.T) {
+	s := newFakeRedis(t)
+	// 服务器时间较慢, 本地已到期的任务在服务器时间下未到期
+	s.update(func() {
+		s.offset = -time.Minute
+	})
+	s.addJob("a", "mail", time.Now().Unix()-10)
+	for _, compat := range []bool{false, true} {
+		compat := compat
+		timer := newTestTimer(t, s, func(config *utils.Config) {
+			config.Delayer.ServerTime = true
+			config.Redis.Compat = compat
+		})
+		timer.tick()
+		assertQueue(t, s, "mail")
+	}
+	// 兼容模式下不使用 EVAL
+	if n := s.count("EVAL"); n != 1 {
+		t.Fatalf("expected EVAL only outside compat mode, got %d", n)
+	}
+}
@@ -0,0 +1,64 @@
+package logic
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dcsunny/delayer/utils"
+)
+
+// 受限服务商禁用的命令
+var disallowedCommands = []string{"EVAL", "EVALSHA", "KEYS", "CLIENT"}
+
+func TestCompatAvoidsDisallowedCommands(t *testing.T) {
+	s := newFakeRedis(t)
+	s.setHook(func(cmd string, args []string) error {
+		for _, disallowed := range disallowedCommands {
+			if cmd == disallowed {
+				return fakeError("ERR unknown command '" + cmd + "'")
+			}
+		}
+		return nil
+	})
+	now := time.Now().Unix()
+	configure := func(config *utils.Config) {
+		config.Redis.Compat = true
+		config.Redis.Database = 2
+		config.Redis.DatabaseSet = true
+		config.Delayer.ServerTime = true
+		config.Delayer.QueueAgeThreshold = 60
+	}
+	s.doIn(2, "ZADD", KEY_JOB_POOL, utils.Int64ToString(now-1), "a")
+	s.doIn(2, "HSET", PREFIX_JOB_BUCKET+"a", FIELD_TOPIC, "mail")
+	s.doIn(2, "ZADD", KEY_JOB_POOL, utils.Int64ToString(now+60), "b")
+	s.doIn(2, "HSET", PREFIX_JOB_BUCKET+"b", FIELD_TOPIC, "mail")
+	timer := newTestTimer(t, s, configure)
+	timer.tick()
+	if ids, _ := s.doIn(2, "LRANGE", PREFIX_READY_QUEUE+"mail", "0", "-1").([]string); len(ids) != 1 || ids[0] != "a" {
+		t.Fatalf("expected job a moved in compat mode, got %v", ids)
+	}
+	config := s.config()
+	configure(&config)
+	admin, err := NewAdmin(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Pool.Close()
+	if stats, err := admin.Stats(); err != nil || stats["mail"] != 1 {
+		t.Fatalf("expected stats in compat mode, got %v (%v)", stats, err)
+	}
+	if n, err := admin.DelayTopic("mail", time.Minute); err != nil || n != 1 {
+		t.Fatalf("expected 1 delayed job in compat mode, got %d (%v)", n, err)
+	}
+	for _, cmd := range disallowedCommands {
+		if n := s.count(cmd); n != 0 {
+			t.Errorf("expected no %s in compat mode, got %d", cmd, n)
+		}
+	}
+	if n := s.count("SELECT"); n == 0 {
+		t.Error("expected SELECT to be used without CLIENT INFO verification")
+	}
+	if names := s.clientNames(); len(names) != 0 {
+		t.Errorf("expected no connection names in compat mode, got %v", names)
+	}
+}
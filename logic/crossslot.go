@@ -0,0 +1,142 @@
+package logic
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dcsunny/delayer/utils"
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	// 跨槽位移动时插入ReadyQueue的最大尝试次数
+	CROSS_SLOT_PUSH_ATTEMPTS = 3
+)
+
+// 跨槽位分步移动: 更新Bucket, 逐个移出JobPool, 插入ReadyQueue, 插入失败时重试, 仍失败则放回JobPool, 避免任务丢失
+func (p *Timer) moveAcrossSlots(jobIDs []string, topic string, scores map[string]int64) bool {
+	conn := p.pool().Get()
+	defer conn.Close()
+	// 就绪前处理
+	jobIDs, changes := p.beforeReady(conn, jobIDs)
+	if len(jobIDs) == 0 {
+		return true
+	}
+	jobIDsStr := strings.Join(jobIDs, ",")
+	// 更新Bucket, 失败时任务仍在JobPool中
+	if len(changes) > 0 {
+		if err := p.updateJobBuckets(conn, changes); err != nil {
+			p.fail(err, "updateJobBuckets", jobIDsStr)
+			return false
+		}
+		if err := receiveAll(conn); err != nil {
+			p.fail(err, "updateJobBuckets", jobIDsStr)
+			return false
+		}
+	}
+	// 逐个移出JobPool, 仅移动本实例移出的任务
+	for _, jobID := range jobIDs {
+		conn.Send("ZREM", p.keys.JobPool, jobID)
+	}
+	if err := conn.Flush(); err != nil {
+		p.fail(err, "delJobPool", jobIDsStr)
+		p.restorePool(jobIDs, scores)
+		return false
+	}
+	var removed []string
+	for _, jobID := range jobIDs {
+		n, err := redis.Int64(conn.Receive())
+		if err != nil {
+			// 结果未知, 放回JobPool, ZADD NX 不影响仍在其中的任务
+			p.fail(err, "delJobPool", jobIDsStr)
+			p.restorePool(jobIDs, scores)
+			return false
+		}
+		if n > 0 {
+			removed = append(removed, jobID)
+		}
+	}
+	if len(removed) == 0 {
+		return true
+	}
+	// 插入ReadyQueue, 失败时使用新连接重试
+	removedStr := strings.Join(removed, ",")
+	var err error
+	for attempt := 0; attempt < CROSS_SLOT_PUSH_ATTEMPTS; attempt++ {
+		if err = p.pushReady(removed, topic); err == nil {
+			break
+		}
+		p.fail(err, "addReadyQueue", removedStr)
+	}
+	if err != nil {
+		p.restorePool(removed, scores)
+		return false
+	}
+	if p.stampsReadyAt() {
+		p.stampReadyAt(conn, removed)
+	}
+	p.ready(removed, topic, scores)
+	return true
+}
+
+// 使用新连接插入ReadyQueue并通知
+func (p *Timer) pushReady(jobIDs []string, topic string) error {
+	conn := p.pool().Get()
+	defer conn.Close()
+	if err := p.addReadyQueue(conn, jobIDs, topic); err != nil {
+		return err
+	}
+	if err := receiveAll(conn); err != nil {
+		return err
+	}
+	if p.Config.Delayer.Notify {
+		if _, err := conn.Do("PUBLISH", p.keys.NotifyChannel+topic, strings.Join(jobIDs, ",")); err != nil {
+			p.fail(err, "publish", strings.Join(jobIDs, ","))
+		}
+	}
+	return nil
+}
+
+// 逐个记录 ready_at, 各 bucket 可能不在同一槽位, 失败时仅记录错误
+func (p *Timer) stampReadyAt(conn redis.Conn, jobIDs []string) {
+	for _, jobID := range jobIDs {
+		if err := p.sendReadyAt(conn, []string{jobID}); err != nil {
+			p.fail(err, "stampReadyAt", jobID)
+			return
+		}
+	}
+	if err := receiveAll(conn); err != nil {
+		p.fail(err, "stampReadyAt", strings.Join(jobIDs, ","))
+	}
+}
+
+// 按原就绪时间放回JobPool, 失败时记录任务ID以便人工恢复
+func (p *Timer) restorePool(jobIDs []string, scores map[string]int64) {
+	conn := p.pool().Get()
+	defer conn.Close()
+	args := redis.Args{}.Add(p.keys.JobPool, "NX")
+	for _, jobID := range jobIDs {
+		args = args.Add(scores[jobID], jobID)
+	}
+	if _, err := conn.Do("ZADD", args...); err != nil {
+		jobIDsStr := strings.Join(jobIDs, ",")
+		p.fail(err, "restorePool", jobIDsStr)
+		logger := utils.WithFields(p.Logger, utils.F("ids", jobIDs), utils.F("error", err.Error()))
+		logger.Error(fmt.Sprintf("Job restore failed, IDs: [%s]", jobIDsStr), false)
+	}
+}
+
+// 接收全部待接收的回复, 回复中的错误也视为失败
+func receiveAll(conn redis.Conn) error {
+	reply, err := conn.Do("")
+	if err != nil {
+		return err
+	}
+	values, _ := reply.([]interface{})
+	for _, value := range values {
+		if e, ok := value.(redis.Error); ok {
+			return e
+		}
+	}
+	return nil
+}
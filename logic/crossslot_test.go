@@ -0,0 +1,67 @@
+package logic
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dcsunny/delayer/utils"
+)
+
+// 开启跨槽位分步移动
+func crossSlot(config *utils.Config) {
+	config.Redis.CrossSlot = true
+}
+
+func TestCrossSlotMovesJobs(t *testing.T) {
+	s := newFakeRedis(t)
+	now := time.Now().Unix()
+	s.addJob("a", "mail", now-2)
+	s.addJob("b", "mail", now-1)
+	timer := newTestTimer(t, s, crossSlot)
+	timer.tick()
+	assertQueue(t, s, "mail", "a", "b")
+	if s.count("MULTI") != 0 {
+		t.Fatal("cross slot moves should not use transactions")
+	}
+}
+
+func TestCrossSlotPushFailureKeepsJobs(t *testing.T) {
+	s := newFakeRedis(t)
+	fireAt := time.Now().Unix() - 1
+	s.addJob("a", "mail", fireAt)
+	var attempts int32
+	failQueue(s, "mail", &attempts)
+	timer := newTestTimer(t, s, crossSlot)
+	timer.tick()
+	if n := atomic.LoadInt32(&attempts); n < CROSS_SLOT_PUSH_ATTEMPTS {
+		t.Fatalf("expected at least %d push attempts, got %d", CROSS_SLOT_PUSH_ATTEMPTS, n)
+	}
+	assertQueue(t, s, "mail")
+	// 按原就绪时间放回JobPool
+	score, ok := s.score(KEY_JOB_POOL, "a")
+	if !ok {
+		t.Fatal("job was lost after the push failed")
+	}
+	if int64(score) != fireAt {
+		t.Fatalf("expected score %d, got %v", fireAt, score)
+	}
+}
+
+func TestCrossSlotPushRetried(t *testing.T) {
+	s := newFakeRedis(t)
+	s.addJob("a", "mail", time.Now().Unix()-1)
+	var failed int32
+	s.setHook(func(cmd string, args []string) error {
+		if cmd == "LPUSH" && atomic.CompareAndSwapInt32(&failed, 0, 1) {
+			return errDrop
+		}
+		return nil
+	})
+	timer := newTestTimer(t, s, crossSlot)
+	timer.tick()
+	assertQueue(t, s, "mail", "a")
+	if _, ok := s.score(KEY_JOB_POOL, "a"); ok {
+		t.Fatal("moved job is still in the pool")
+	}
+}
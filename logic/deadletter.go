@@ -0,0 +1,176 @@
+package logic
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dcsunny/delayer/utils"
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	// 定时器拒绝移动的任务所在的死信Topic, 可通过 Admin.ListDead / Admin.ReplayDead 处理
+	DEAD_TOPIC_REJECTED = "_rejected"
+	// 登记Topic时并发冲突的最大尝试次数
+	ADMIT_TOPIC_ATTEMPTS = 3
+	// 拒绝原因字段
+	FIELD_DEAD_REASON = "dead_reason"
+	// 已登记Topic的本地缓存有效期, 过期后重新确认, 使 Admin.UnregisterTopic 生效
+	KNOWN_TOPICS_TTL = time.Minute
+)
+
+// 将任务移出JobPool并放入拒绝死信队列, 记录原因
+func (p *Timer) deadLetter(jobIDs []string, topic string, reason string) bool {
+	if len(jobIDs) == 0 {
+		return true
+	}
+	conn := p.pool().Get()
+	defer conn.Close()
+	jobIDsStr := strings.Join(jobIDs, ",")
+	conn.Send("MULTI")
+	for _, jobID := range jobIDs {
+		conn.Send("HSET", p.keys.JobBucket+jobID, FIELD_DEAD_REASON, reason)
+	}
+	conn.Send("ZREM", redis.Args{}.Add(p.keys.JobPool).AddFlat(jobIDs)...)
+	conn.Send("RPUSH", redis.Args{}.Add(p.keys.DeadQueue+DEAD_TOPIC_REJECTED).AddFlat(jobIDs)...)
+	if _, err := conn.Do("EXEC"); err != nil {
+		p.fail(err, "deadLetter", jobIDsStr)
+		return false
+	}
+	if p.topicCache != nil {
+		for _, jobID := range jobIDs {
+			p.topicCache.remove(jobID)
+		}
+	}
+	logger := utils.WithFields(p.Logger, utils.F("topic", topic), utils.F("reason", reason), utils.F("ids", jobIDs))
+	logger.Warn(fmt.Sprintf("Job rejected, topic: %s, reason: %s, id: %s", topic, reason, jobIDsStr))
+	if p.OnDeadLetter != nil {
+		p.OnDeadLetter(topic, jobIDs, reason)
+	}
+	return true
+}
+
+// 限制Topic数量, 已登记的Topic记录在 delayer:topics 中, 各实例共享, 超出 max_topics 的Topic任务放入拒绝死信队列
+// 自检与 empty_topic 等内部Topic不计入上限
+func (p *Timer) limitTopics(topics map[string][]string) map[string][]string {
+	limit := p.Config.Delayer.MaxTopics
+	if limit <= 0 {
+		return topics
+	}
+	conn := p.pool().Get()
+	defer conn.Close()
+	if p.knownTopics == nil || time.Since(p.knownTopicsTime) >= KNOWN_TOPICS_TTL {
+		if err := p.seedTopics(conn); err != nil {
+			p.fail(err, "seedTopics", "")
+			return topics
+		}
+		p.knownTopics = make(map[string]bool)
+		p.knownTopicsTime = time.Now()
+	}
+	for _, topic := range sortedTopics(topics) {
+		if p.knownTopics[topic] || p.internalTopic(topic) {
+			continue
+		}
+		admitted, err := p.admitTopic(conn, topic, limit)
+		if err != nil {
+			p.fail(err, "limitTopics", topic)
+			delete(topics, topic)
+			continue
+		}
+		if admitted {
+			p.knownTopics[topic] = true
+			continue
+		}
+		p.deadLetter(topics[topic], topic, fmt.Sprintf("max topics %d exceeded", limit))
+		delete(topics, topic)
+	}
+	return topics
+}
+
+// 内部使用的Topic
+func (p *Timer) internalTopic(topic string) bool {
+	return topic == SELF_TEST_TOPIC || (p.Config.Delayer.EmptyTopic != "" && topic == p.Config.Delayer.EmptyTopic)
+}
+
+// 首次使用时按已有的ReadyQueue登记Topic, 避免开启 max_topics 前已在使用的Topic被拒绝
+func (p *Timer) seedTopics(conn redis.Conn) error {
+	exists, err := redis.Bool(conn.Do("EXISTS", p.keys.Topics))
+	if err != nil || exists {
+		return err
+	}
+	cursor := "0"
+	for {
+		values, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", p.keys.ReadyQueue+"*", "COUNT", SCAN_BATCH_SIZE))
+		if err != nil {
+			return err
+		}
+		cursor, _ = redis.String(values[0], nil)
+		queues, _ := redis.Strings(values[1], nil)
+		args := redis.Args{}.Add(p.keys.Topics)
+		for _, queue := range queues {
+			if topic := strings.TrimPrefix(queue, p.keys.ReadyQueue); !p.internalTopic(topic) {
+				args = args.Add(topic)
+			}
+		}
+		if len(args) > 1 {
+			if _, err := conn.Do("SADD", args...); err != nil {
+				return err
+			}
+		}
+		if cursor == "0" {
+			return nil
+		}
+	}
+}
+
+// 注销Topic, 释放 max_topics 名额, 不删除ReadyQueue及其中的任务, 各定时器在 KNOWN_TOPICS_TTL 内生效, 返回Topic是否已登记
+func (p *Admin) UnregisterTopic(topic string) (bool, error) {
+	conn := p.Pool.Get()
+	defer conn.Close()
+	return redis.Bool(conn.Do("SREM", p.keys().Topics, topic))
+}
+
+// 获取已登记的Topic
+func (p *Admin) RegisteredTopics() ([]string, error) {
+	conn := p.Pool.Get()
+	defer conn.Close()
+	topics, err := redis.Strings(conn.Do("SMEMBERS", p.keys().Topics))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(topics)
+	return topics, nil
+}
+
+// 登记Topic, 已登记或未达上限时返回 true, 监视 delayer:topics 避免多实例同时登记超出上限
+func (p *Timer) admitTopic(conn redis.Conn, topic string, limit int) (bool, error) {
+	for attempt := 0; attempt < ADMIT_TOPIC_ATTEMPTS; attempt++ {
+		if _, err := conn.Do("WATCH", p.keys.Topics); err != nil {
+			return false, err
+		}
+		member, err := redis.Bool(conn.Do("SISMEMBER", p.keys.Topics, topic))
+		if err != nil || member {
+			conn.Do("UNWATCH")
+			return member, err
+		}
+		count, err := redis.Int(conn.Do("SCARD", p.keys.Topics))
+		if err != nil || count >= limit {
+			conn.Do("UNWATCH")
+			return false, err
+		}
+		conn.Send("MULTI")
+		conn.Send("SADD", p.keys.Topics, topic)
+		reply, err := conn.Do("EXEC")
+		if err != nil {
+			return false, err
+		}
+		// 监视的集合已变更, 重新判断
+		if reply != nil {
+			return true, nil
+		}
+	}
+	return false, errors.New("topic registration conflicted")
+}
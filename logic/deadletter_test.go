@@ -0,0 +1,283 @@
+package logic
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/dcsunny/delayer/utils"
+)
+
+// 拒绝死信队列中的任务
+func rejected(s *fakeRedis) []string {
+	ids, _ := s.do("LRANGE", PREFIX_DEAD_QUEUE+DEAD_TOPIC_REJECTED, "0", "-1").([]string)
+	return ids
+}
+
+func TestMaxTopicsRejectsNewTopics(t *testing.T) {
+	s := newFakeRedis(t)
+	now := time.Now().Unix()
+	s.addJob("a", "t1", now-1)
+	s.addJob("b", "t2", now-1)
+	s.addJob("c", "t3", now-1)
+	timer := newTestTimer(t, s, func(config *utils.Config) {
+		config.Delayer.MaxTopics = 2
+	})
+	var reasons []string
+	timer.OnDeadLetter = func(topic string, jobIDs []string, reason string) {
+		reasons = append(reasons, topic+": "+reason)
+	}
+	timer.tick()
+	assertQueue(t, s, "t1", "a")
+	assertQueue(t, s, "t2", "b")
+	assertQueue(t, s, "t3")
+	if ids := rejected(s); !reflect.DeepEqual(ids, []string{"c"}) {
+		t.Fatalf("expected [c] to be rejected, got %v", ids)
+	}
+	if v := s.field("c", FIELD_DEAD_REASON); v != "max topics 2 exceeded" {
+		t.Errorf("unexpected dead reason %q", v)
+	}
+	if len(reasons) != 1 {
+		t.Fatalf("expected a single dead letter callback, got %v", reasons)
+	}
+	if _, ok := s.score(KEY_JOB_POOL, "c"); ok {
+		t.Fatal("rejected job is still in the pool")
+	}
+	// 已登记的Topic不受上限影响
+	s.addJob("d", "t1", now-1)
+	timer.tick()
+	assertQueue(t, s, "t1", "a", "d")
+}
+
+func TestMaxTopicsSharedAcrossTimers(t *testing.T) {
+	s := newFakeRedis(t)
+	configure := func(config *utils.Config) {
+		config.Delayer.MaxTopics = 1
+	}
+	first := newTestTimer(t, s, configure)
+	second := newTestTimer(t, s, configure)
+	now := time.Now().Unix()
+	s.addJob("a", "t1", now-1)
+	first.tick()
+	// 另一实例不能再登记新Topic, 但可移动已登记的Topic
+	s.addJob("b", "t2", now-1)
+	s.addJob("c", "t1", now-1)
+	second.tick()
+	assertQueue(t, s, "t1", "a", "c")
+	assertQueue(t, s, "t2")
+	if ids := rejected(s); !reflect.DeepEqual(ids, []string{"b"}) {
+		t.Fatalf("expected [b] to be rejected, got %v", ids)
+	}
+	if members, _ := s.do("SMEMBERS", KEY_TOPICS).([]string); !reflect.DeepEqual(members, []string{"t1"}) {
+		t.Fatalf("expected only t1 to be registered, got %v", members)
+	}
+}
+
+func TestMaxTopicsUnlimited(t *testing.T) {
+	s := newFakeRedis(t)
+	now := time.Now().Unix()
+	s.addJob("a", "t1", now-1)
+	s.addJob("b", "t2", now-1)
+	timer := newTestTimer(t, s, nil)
+	timer.tick()
+	assertQueue(t, s, "t1", "a")
+	assertQueue(t, s, "t2", "b")
+	if s.count("SADD") != 0 {
+		t.Fatal("topics should not be registered without a limit")
+	}
+}
+
+func TestMaxTopicsSeededFromReadyQueues(t *testing.T) {
+	s := newFakeRedis(t)
+	now := time.Now().Unix()
+	// 开启上限前已在使用的Topic
+	s.do("LPUSH", PREFIX_READY_QUEUE+"old", "x")
+	s.do("LPUSH", PREFIX_READY_QUEUE+SELF_TEST_TOPIC, "y")
+	s.addJob("a", "old", now-1)
+	s.addJob("b", "new", now-1)
+	timer := newTestTimer(t, s, func(config *utils.Config) {
+		config.Delayer.MaxTopics = 1
+	})
+	timer.tick()
+	assertQueue(t, s, "old", "x", "a")
+	if ids := rejected(s); !reflect.DeepEqual(ids, []string{"b"}) {
+		t.Fatalf("expected [b] to be rejected, got %v", ids)
+	}
+	if members, _ := s.do("SMEMBERS", KEY_TOPICS).([]string); !reflect.DeepEqual(members, []string{"old"}) {
+		t.Fatalf("expected only old to be registered, got %v", members)
+	}
+}
+
+func TestMaxTopicsExcludesInternalTopics(t *testing.T) {
+	s := newFakeRedis(t)
+	now := time.Now().Unix()
+	s.addJob("a", "t1", now-1)
+	s.addJob("self", SELF_TEST_TOPIC, now-1)
+	s.do("ZADD", KEY_JOB_POOL, strconv.FormatInt(now-1, 10), "empty")
+	s.do("HSET", PREFIX_JOB_BUCKET+"empty", FIELD_TOPIC, "")
+	timer := newTestTimer(t, s, func(config *utils.Config) {
+		config.Delayer.MaxTopics = 1
+		config.Delayer.EmptyTopic = "_empty"
+	})
+	timer.tick()
+	assertQueue(t, s, "t1", "a")
+	assertQueue(t, s, SELF_TEST_TOPIC, "self")
+	assertQueue(t, s, "_empty", "empty")
+	if ids := rejected(s); len(ids) != 0 {
+		t.Fatalf("expected no rejected jobs, got %v", ids)
+	}
+}
+
+func TestUnregisterTopic(t *testing.T) {
+	s := newFakeRedis(t)
+	now := time.Now().Unix()
+	s.addJob("a", "t1", now-1)
+	timer := newTestTimer(t, s, func(config *utils.Config) {
+		config.Delayer.MaxTopics = 1
+	})
+	timer.tick()
+	admin := newTestAdmin(t, s)
+	ok, err := admin.UnregisterTopic("t1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("expected t1 to be registered")
+	}
+	if topics, err := admin.RegisteredTopics(); err != nil || len(topics) != 0 {
+		t.Fatalf("expected no registered topics, got %v (%v)", topics, err)
+	}
+	// 本地缓存过期后释放的名额可供新Topic使用
+	timer.knownTopicsTime = time.Now().Add(-KNOWN_TOPICS_TTL)
+	s.do("DEL", PREFIX_READY_QUEUE+"t1")
+	s.addJob("b", "t2", now-1)
+	timer.tick()
+	assertQueue(t, s, "t2", "b")
+}
+
+// 写入死信队列中的任务
+func addDeadJobs(s *fakeRedis, topic string, jobIDs ...string) {
+	for _, jobID := range jobIDs {
+		s.do("HSET", PREFIX_JOB_BUCKET+jobID, FIELD_TOPIC, topic, FIELD_RETRIES, "3")
+		s.do("RPUSH", PREFIX_DEAD_QUEUE+topic, jobID)
+	}
+}
+
+func TestListDead(t *testing.T) {
+	s := newFakeRedis(t)
+	addDeadJobs(s, "mail", "a", "b", "c")
+	addDeadJobs(s, "sms", "d")
+	// bucket 已删除的任务跳过
+	s.do("DEL", PREFIX_JOB_BUCKET+"b")
+	admin := newTestAdmin(t, s)
+	jobs, err := admin.ListDead("mail", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, job := range jobs {
+		ids = append(ids, job.ID)
+		if job.Topic != "mail" || job.Retries != 3 {
+			t.Errorf("unexpected dead job %+v", job)
+		}
+	}
+	if !reflect.DeepEqual(ids, []string{"a", "c"}) {
+		t.Fatalf("expected dead jobs [a c], got %v", ids)
+	}
+	jobs, err = admin.ListDead("mail", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 || jobs[0].ID != "a" {
+		t.Fatalf("expected the limit to return only a, got %v", jobs)
+	}
+}
+
+func TestReplayDead(t *testing.T) {
+	s := newFakeRedis(t)
+	addDeadJobs(s, "mail", "a", "b")
+	admin := newTestAdmin(t, s)
+	before := time.Now()
+	// 不在死信队列中的任务忽略
+	if err := admin.ReplayDead("mail", []string{"a", "missing"}); err != nil {
+		t.Fatal(err)
+	}
+	if ids := s.list(PREFIX_DEAD_QUEUE + "mail"); !reflect.DeepEqual(ids, []string{"b"}) {
+		t.Fatalf("expected only b left in the dead queue, got %v", ids)
+	}
+	if _, ok := s.score(KEY_JOB_POOL, "missing"); ok {
+		t.Fatal("unknown job was added to the pool")
+	}
+	score, ok := s.score(KEY_JOB_POOL, "a")
+	if !ok {
+		t.Fatal("replayed job is not in the pool")
+	}
+	if fireAt := int64(score); fireAt < before.Add(REPLAY_DELAY).Unix() || fireAt > time.Now().Add(REPLAY_DELAY).Unix() {
+		t.Fatalf("expected the replayed job delayed by %s, got score %d", REPLAY_DELAY, fireAt)
+	}
+	if v := s.field("a", FIELD_RETRIES); v != "0" {
+		t.Fatalf("expected the retry count reset, got %q", v)
+	}
+	timer := newTestTimer(t, s, func(config *utils.Config) {
+		config.Delayer.ServerTime = true
+	})
+	// 延迟到期前不移动
+	timer.tick()
+	assertQueue(t, s, "mail")
+	s.update(func() {
+		s.offset = REPLAY_DELAY + time.Second
+	})
+	timer.tick()
+	assertQueue(t, s, "mail", "a")
+}
+
+func TestEmptyTopicRouted(t *testing.T) {
+	s := newFakeRedis(t)
+	now := time.Now().Unix()
+	s.do("ZADD", KEY_JOB_POOL, strconv.FormatInt(now-1, 10), "empty")
+	s.do("HSET", PREFIX_JOB_BUCKET+"empty", FIELD_TOPIC, "")
+	// 缺少 topic 字段
+	s.do("ZADD", KEY_JOB_POOL, strconv.FormatInt(now-1, 10), "missing")
+	s.do("HSET", PREFIX_JOB_BUCKET+"missing", "body", "x")
+	timer := newTestTimer(t, s, func(config *utils.Config) {
+		config.Delayer.EmptyTopic = "_empty"
+	})
+	timer.tick()
+	assertQueue(t, s, "_empty", "empty", "missing")
+	if n, _ := s.do("ZCARD", KEY_JOB_POOL).(int64); n != 0 {
+		t.Fatalf("expected empty-topic jobs to leave the pool, %d left", n)
+	}
+}
+
+func TestEmptyTopicDeadLettered(t *testing.T) {
+	s := newFakeRedis(t)
+	now := time.Now().Unix()
+	s.do("ZADD", KEY_JOB_POOL, strconv.FormatInt(now-1, 10), "empty")
+	s.do("HSET", PREFIX_JOB_BUCKET+"empty", FIELD_TOPIC, "")
+	s.addJob("a", "mail", now-1)
+	timer := newTestTimer(t, s, nil)
+	var reasons []string
+	timer.OnDeadLetter = func(topic string, jobIDs []string, reason string) {
+		reasons = append(reasons, reason)
+	}
+	timer.tick()
+	assertQueue(t, s, "mail", "a")
+	if ids := rejected(s); !reflect.DeepEqual(ids, []string{"empty"}) {
+		t.Fatalf("expected the empty-topic job to be rejected, got %v", ids)
+	}
+	if v := s.field("empty", FIELD_DEAD_REASON); v != "empty topic" {
+		t.Errorf("unexpected dead reason %q", v)
+	}
+	if !reflect.DeepEqual(reasons, []string{"empty topic"}) {
+		t.Fatalf("expected a single dead letter callback, got %v", reasons)
+	}
+	// 不再留在JobPool中被反复扫描
+	if _, ok := s.score(KEY_JOB_POOL, "empty"); ok {
+		t.Fatal("empty-topic job is still in the pool")
+	}
+	timer.tick()
+	if ids := rejected(s); len(ids) != 1 {
+		t.Fatalf("expected the job to be rejected once, got %v", ids)
+	}
+}
@@ -0,0 +1,91 @@
+package logic
+
+import (
+	"io"
+	"net"
+	"strings"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// 错误分类, 用于告警路由
+type ErrorCategory string
+
+const (
+	ERROR_CONNECTION ErrorCategory = "connection"
+	ERROR_TIMEOUT    ErrorCategory = "timeout"
+	ERROR_AUTH       ErrorCategory = "auth"
+	ERROR_SCRIPT     ErrorCategory = "script"
+	ERROR_DATA       ErrorCategory = "data"
+	ERROR_CONFIG     ErrorCategory = "config"
+	ERROR_UNKNOWN    ErrorCategory = "unknown"
+)
+
+// 错误分类, HandleError 中可据此区分处理, 单次执行的汇总错误按首个错误分类
+// 配置错误按原始错误分类, 无法分类的 (配置无效, 键类型错误, 数据库不一致等) 归为 ERROR_CONFIG
+func Categorize(err error) ErrorCategory {
+	switch e := err.(type) {
+	case nil:
+		return ERROR_UNKNOWN
+	case *TickError:
+		return e.Category
+	case *ConfigError:
+		if e.Category != "" {
+			return e.Category
+		}
+		if c := Categorize(e.Err); c != ERROR_UNKNOWN {
+			return c
+		}
+		return ERROR_CONFIG
+	case net.Error:
+		if e.Timeout() {
+			return ERROR_TIMEOUT
+		}
+		return ERROR_CONNECTION
+	case redis.Error:
+		return categorizeReply(e.Error())
+	}
+	if err == redis.ErrNil {
+		return ERROR_DATA
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF || err == redis.ErrPoolExhausted {
+		return ERROR_CONNECTION
+	}
+	message := err.Error()
+	if strings.Contains(message, "i/o timeout") {
+		return ERROR_TIMEOUT
+	}
+	if strings.Contains(message, "closed") || strings.Contains(message, "connection") {
+		return ERROR_CONNECTION
+	}
+	// redigo 类型转换错误
+	if strings.HasPrefix(message, "redigo: unexpected") {
+		return ERROR_DATA
+	}
+	return ERROR_UNKNOWN
+}
+
+// Redis错误回复分类
+func categorizeReply(message string) ErrorCategory {
+	for _, prefix := range []string{"WRONGPASS", "NOAUTH", "NOPERM", "ERR invalid password", "ERR AUTH", "ERR Client sent AUTH"} {
+		if strings.HasPrefix(message, prefix) {
+			return ERROR_AUTH
+		}
+	}
+	for _, prefix := range []string{"NOSCRIPT", "BUSY", "ERR Error running script", "ERR Error compiling script", "ERR user_script"} {
+		if strings.HasPrefix(message, prefix) {
+			return ERROR_SCRIPT
+		}
+	}
+	for _, prefix := range []string{"READONLY", "MASTERDOWN", "LOADING", "CLUSTERDOWN", "MOVED", "ASK", "TRYAGAIN"} {
+		if strings.HasPrefix(message, prefix) {
+			return ERROR_CONNECTION
+		}
+	}
+	for _, prefix := range []string{"WRONGTYPE", "EXECABORT", "ERR value is not", "ERR wrong number", "ERR syntax", "OOM"} {
+		if strings.HasPrefix(message, prefix) {
+			return ERROR_DATA
+		}
+	}
+	return ERROR_UNKNOWN
+}
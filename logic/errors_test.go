@@ -0,0 +1,88 @@
+package logic
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// 超时的网络错误
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestCategorize(t *testing.T) {
+	for _, c := range []struct {
+		err      error
+		expected ErrorCategory
+	}{
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, ERROR_CONNECTION},
+		{timeoutError{}, ERROR_TIMEOUT},
+		{io.EOF, ERROR_CONNECTION},
+		{redis.ErrPoolExhausted, ERROR_CONNECTION},
+		{redis.Error("READONLY You can't write against a read only replica."), ERROR_CONNECTION},
+		{redis.Error("NOAUTH Authentication required."), ERROR_AUTH},
+		{redis.Error("WRONGPASS invalid username-password pair"), ERROR_AUTH},
+		{redis.Error("NOSCRIPT No matching script."), ERROR_SCRIPT},
+		{redis.Error("WRONGTYPE Operation against a key holding the wrong kind of value"), ERROR_DATA},
+		{redis.ErrNil, ERROR_DATA},
+		{&ConfigError{Err: errors.New("bad password file"), Category: ERROR_AUTH}, ERROR_AUTH},
+		{&ConfigError{Err: redis.Error("WRONGPASS invalid username-password pair")}, ERROR_AUTH},
+		{&ConfigError{Err: errors.New("key delayer:job_pool has type string, expected zset")}, ERROR_CONFIG},
+		{&ConfigError{Err: errors.New("selected database mismatch, expected 1, got 0")}, ERROR_CONFIG},
+		{errors.New("something else"), ERROR_UNKNOWN},
+	} {
+		if category := Categorize(c.err); category != c.expected {
+			t.Errorf("Categorize(%v): expected %s, got %s", c.err, c.expected, category)
+		}
+	}
+}
+
+func TestTickErrorKeepsRawError(t *testing.T) {
+	raw := redis.Error("READONLY You can't write against a read only replica.")
+	errs := &TickError{}
+	errs.add(raw, "commit", "a,b")
+	errs.add(io.EOF, "getJobTopic", "c")
+	if !errors.Is(errs, raw) {
+		t.Fatal("expected the first raw error to be reachable through Unwrap")
+	}
+	var reply redis.Error
+	if !errors.As(errs, &reply) || reply != raw {
+		t.Fatalf("expected errors.As to find the redis error, got %v", reply)
+	}
+	if Categorize(errs) != ERROR_CONNECTION {
+		t.Fatalf("expected the first error's category, got %s", Categorize(errs))
+	}
+}
+
+func TestOnErrorReceivesCategory(t *testing.T) {
+	s := newFakeRedis(t)
+	s.addJob("a", "mail", time.Now().Unix()-1)
+	s.setHook(func(cmd string, args []string) error {
+		if cmd == "LPUSH" {
+			return fakeError("READONLY You can't write against a read only replica.")
+		}
+		return nil
+	})
+	timer := newTestTimer(t, s, nil)
+	var categories []ErrorCategory
+	var raw error
+	timer.OnError = func(category ErrorCategory, err error, funcName string, data string) {
+		categories = append(categories, category)
+		raw = err
+	}
+	timer.tick()
+	if len(categories) != 1 || categories[0] != ERROR_CONNECTION {
+		t.Fatalf("expected a single connection error, got %v", categories)
+	}
+	var reply redis.Error
+	if !errors.As(raw, &reply) {
+		t.Fatalf("expected the raw redis error to be available, got %v", raw)
+	}
+}
@@ -0,0 +1,106 @@
+package logic
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// 导出记录
+type exportRecord struct {
+	ID    string `json:"id"`
+	Score int64  `json:"score"`
+	// 所在集合, 见 SOURCE_*, 缺失时为 job_pool, 兼容旧版本导出的文件
+	Source string            `json:"source,omitempty"`
+	Fields map[string]string `json:"fields"`
+}
+
+// 导出全部等待的任务, 包括暂存与隔离的任务, 记录所在集合与分数, 每行一条 JSON, 逐批读取不会一次载入全部任务
+func (p *Admin) Export(w io.Writer) error {
+	conn := p.Pool.Get()
+	defer conn.Close()
+	sets, err := p.pendingSets(conn)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	for _, set := range sets {
+		cursor := "0"
+		for {
+			values, err := redis.Values(conn.Do("ZSCAN", set.Key, cursor, "COUNT", SCAN_BATCH_SIZE))
+			if err != nil {
+				return err
+			}
+			cursor, _ = redis.String(values[0], nil)
+			scores, _ := scoreMap(values[1], nil)
+			for jobID, score := range scores {
+				fields, err := redis.StringMap(conn.Do("HGETALL", p.keys().JobBucket+jobID))
+				if err != nil {
+					return err
+				}
+				if len(fields) == 0 {
+					continue
+				}
+				if err := encoder.Encode(exportRecord{ID: jobID, Score: score, Source: set.Source, Fields: fields}); err != nil {
+					return err
+				}
+			}
+			if cursor == "0" {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// 导入 Export 导出的任务, 按原分数放回所在集合, 已存在的任务会被覆盖, 可重复执行
+// 暂存集合中的任务同时恢复其Topic的暂停状态, 否则不会被放回JobPool
+func (p *Admin) Import(r io.Reader) error {
+	conn := p.Pool.Get()
+	defer conn.Close()
+	keys := p.keys()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var record exportRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return err
+		}
+		if record.ID == "" || len(record.Fields) == 0 {
+			continue
+		}
+		source := record.Source
+		if source == "" {
+			source = SOURCE_JOB_POOL
+		}
+		key, ok := p.sourceKey(source)
+		if !ok {
+			return fmt.Errorf("job %s has unknown source %q", record.ID, record.Source)
+		}
+		conn.Send("MULTI")
+		conn.Send("DEL", keys.JobBucket+record.ID)
+		conn.Send("HMSET", redis.Args{}.Add(keys.JobBucket+record.ID).AddFlat(record.Fields)...)
+		// 同一任务只保留在一个集合中
+		for _, other := range []string{keys.JobPool, keys.RetryPool, keys.Quarantine} {
+			if other != key {
+				conn.Send("ZREM", other, record.ID)
+			}
+		}
+		conn.Send("ZADD", key, record.Score, record.ID)
+		if strings.HasPrefix(source, PREFIX_SOURCE_HELD) {
+			conn.Send("SADD", keys.HeldTopics, strings.TrimPrefix(source, PREFIX_SOURCE_HELD))
+		}
+		if _, err := conn.Do("EXEC"); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,74 @@
+package logic
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExportImportRoundTrip(t *testing.T) {
+	src := newFakeRedis(t)
+	src.addJob("pending", "mail", 100)
+	src.do("HSET", PREFIX_JOB_BUCKET+"pending", "body", "hello")
+	// 暂存, 暂停与隔离的任务也需导出
+	src.do("HSET", PREFIX_JOB_BUCKET+"retry", FIELD_TOPIC, "mail")
+	src.do("ZADD", KEY_RETRY_POOL, "200", "retry")
+	src.do("HSET", PREFIX_JOB_BUCKET+"held", FIELD_TOPIC, "sms")
+	src.do("ZADD", PREFIX_HELD_POOL+"sms", "300", "held")
+	src.do("SADD", KEY_HELD_TOPICS, "sms")
+	src.do("HSET", PREFIX_JOB_BUCKET+"bad", FIELD_TOPIC, "mail")
+	src.do("ZADD", KEY_QUARANTINE, "-1", "bad")
+	var buf bytes.Buffer
+	if err := newTestAdmin(t, src).Export(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(buf.String(), "\n"); n != 4 {
+		t.Fatalf("expected 4 records, got %d: %s", n, buf.String())
+	}
+
+	dst := newFakeRedis(t)
+	if err := newTestAdmin(t, dst).Import(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]struct {
+		key   string
+		score float64
+	}{
+		"pending": {KEY_JOB_POOL, 100},
+		"retry":   {KEY_RETRY_POOL, 200},
+		"held":    {PREFIX_HELD_POOL + "sms", 300},
+		"bad":     {KEY_QUARANTINE, -1},
+	}
+	for jobID, e := range expected {
+		score, ok := dst.score(e.key, jobID)
+		if !ok || score != e.score {
+			t.Errorf("job %s: expected score %v in %s, got %v (%v)", jobID, e.score, e.key, score, ok)
+		}
+		if _, ok := dst.score(KEY_JOB_POOL, jobID); ok && e.key != KEY_JOB_POOL {
+			t.Errorf("job %s was also imported into the pool", jobID)
+		}
+	}
+	if v := dst.field("pending", "body"); v != "hello" {
+		t.Fatalf("expected bucket fields to be imported, got %q", v)
+	}
+	// 暂存集合中的任务恢复Topic的暂停状态
+	if topics := dst.do("SMEMBERS", KEY_HELD_TOPICS); !reflect.DeepEqual(topics, []string{"sms"}) {
+		t.Fatalf("expected sms to be held, got %v", topics)
+	}
+}
+
+func TestImportLegacyRecord(t *testing.T) {
+	s := newFakeRedis(t)
+	line := `{"id":"a","score":100,"fields":{"topic":"mail"}}` + "\n"
+	if err := newTestAdmin(t, s).Import(strings.NewReader(line)); err != nil {
+		t.Fatal(err)
+	}
+	if score, ok := s.score(KEY_JOB_POOL, "a"); !ok || score != 100 {
+		t.Fatalf("expected a record without source to go to the pool, got %v (%v)", score, ok)
+	}
+	line = `{"id":"b","score":100,"source":"nowhere","fields":{"topic":"mail"}}` + "\n"
+	if err := newTestAdmin(t, s).Import(strings.NewReader(line)); err == nil {
+		t.Fatal("expected an error for an unknown source")
+	}
+}
@@ -0,0 +1,91 @@
+package logic
+
+import (
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	FAIR_SCAN_FACTOR = 4
+)
+
+// 是否公平分配
+func (p *Timer) fairScheduling() bool {
+	return p.Config.Delayer.BatchSize > 0 && p.Config.Delayer.FairScheduling
+}
+
+// 首个窗口已取满时继续扫描后续窗口, 直至到期任务全部扫描或累计达到 MAX_BATCH_SIZE,
+// 避免任务较少的Topic排在热点Topic的积压之后, 始终不在首个窗口中而无法移动
+func (p *Timer) scanFairCandidates(topics map[string][]string, scores map[string]int64, scanned int) {
+	window := p.scanLimit()
+	if scanned < window {
+		return
+	}
+	conn := p.pool().Get()
+	defer conn.Close()
+	now := p.now(conn)
+	for scanned < MAX_BATCH_SIZE {
+		args := redis.Args{}.Add(p.keys.JobPool, "0", now, "WITHSCORES", "LIMIT", scanned, window)
+		more, err := scoreMap(conn.Do("ZRANGEBYSCORE", args...))
+		if err != nil {
+			p.fail(err, "scanFairCandidates", "")
+			return
+		}
+		jobs := make([]string, 0, len(more))
+		for jobID, score := range more {
+			if _, ok := scores[jobID]; ok {
+				continue
+			}
+			scores[jobID] = score
+			jobs = append(jobs, jobID)
+		}
+		for topic, jobIDs := range p.jobTopics(jobs) {
+			topics[topic] = append(topics[topic], jobIDs...)
+		}
+		scanned += len(more)
+		if len(more) < window {
+			return
+		}
+	}
+}
+
+// 按权重轮转分配单次执行的任务配额
+func fairShare(topics map[string][]string, scores map[string]int64, weights map[string]int, budget int) map[string][]string {
+	for _, jobIDs := range topics {
+		sortJobIDs(jobIDs, scores)
+	}
+	names := sortedTopics(topics)
+	shares := make(map[string][]string)
+	offsets := make(map[string]int)
+	for budget > 0 {
+		progressed := false
+		for _, topic := range names {
+			weight := weights[topic]
+			if weight <= 0 {
+				weight = 1
+			}
+			jobIDs := topics[topic]
+			offset := offsets[topic]
+			n := len(jobIDs) - offset
+			if n > weight {
+				n = weight
+			}
+			if n > budget {
+				n = budget
+			}
+			if n <= 0 {
+				continue
+			}
+			shares[topic] = append(shares[topic], jobIDs[offset:offset+n]...)
+			offsets[topic] = offset + n
+			budget -= n
+			progressed = true
+			if budget == 0 {
+				break
+			}
+		}
+		if !progressed {
+			break
+		}
+	}
+	return shares
+}
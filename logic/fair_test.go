@@ -0,0 +1,68 @@
+package logic
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/dcsunny/delayer/utils"
+)
+
+func TestFairShareWeights(t *testing.T) {
+	topics := make(map[string][]string)
+	scores := make(map[string]int64)
+	for i := 0; i < 20; i++ {
+		for _, topic := range []string{"hot", "warm", "cold"} {
+			jobID := fmt.Sprintf("%s-%02d", topic, i)
+			topics[topic] = append(topics[topic], jobID)
+			scores[jobID] = int64(i)
+		}
+	}
+	shares := fairShare(topics, scores, map[string]int{"hot": 2}, 12)
+	expected := map[string]int{"hot": 6, "warm": 3, "cold": 3}
+	for topic, n := range expected {
+		if len(shares[topic]) != n {
+			t.Errorf("topic %s: expected %d jobs, got %d", topic, n, len(shares[topic]))
+		}
+	}
+	// 各Topic按就绪时间取最早的任务
+	if shares["cold"][0] != "cold-00" {
+		t.Errorf("expected the earliest cold job first, got %s", shares["cold"][0])
+	}
+}
+
+func TestFairSchedulingPerTick(t *testing.T) {
+	s := newFakeRedis(t)
+	now := time.Now().Unix()
+	// 热点Topic的任务更早, 未公平分配时会占满配额
+	for i := 0; i < 10; i++ {
+		s.addJob(fmt.Sprintf("hot-%d", i), "hot", now-100+int64(i))
+	}
+	for i := 0; i < 3; i++ {
+		s.addJob(fmt.Sprintf("cold-%d", i), "cold", now-10+int64(i))
+	}
+	timer := newTestTimer(t, s, func(config *utils.Config) {
+		config.Delayer.BatchSize = 4
+		config.Delayer.FairScheduling = true
+	})
+	timer.tick()
+	assertQueue(t, s, "hot", "hot-0", "hot-1")
+	assertQueue(t, s, "cold", "cold-0", "cold-1")
+}
+
+func TestFairSchedulingFindsColdTopic(t *testing.T) {
+	s := newFakeRedis(t)
+	now := time.Now().Unix()
+	// 热点Topic的积压远超首个扫描窗口, 冷门Topic的任务排在最后
+	for i := 0; i < 100; i++ {
+		s.addJob(fmt.Sprintf("hot-%03d", i), "hot", now-200+int64(i))
+	}
+	s.addJob("cold-0", "cold", now-1)
+	timer := newTestTimer(t, s, func(config *utils.Config) {
+		config.Delayer.BatchSize = 4
+		config.Delayer.FairScheduling = true
+	})
+	timer.tick()
+	assertQueue(t, s, "cold", "cold-0")
+	assertQueue(t, s, "hot", "hot-000", "hot-001", "hot-002")
+}
@@ -0,0 +1,483 @@
+package logic
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alicebob/miniredis/v2/server"
+	"github.com/dcsunny/delayer/utils"
+	"github.com/gomodule/redigo/redis"
+)
+
+// 测试用的进程内Redis, 数据与命令由 miniredis 实现
+// miniredis 无法按命令注入故障, 也未实现 OBJECT 与 CLIENT INFO, 通过前置钩子补充:
+// 错误回复, 断开连接, 阻塞不回复, 慢命令, 服务器时间偏移, 以及命令计数
+type fakeRedis struct {
+	*miniredis.Miniredis
+	t       testing.TB
+	closing chan struct{}
+	closed  sync.Once
+
+	mutex    sync.Mutex
+	sessions map[*server.Peer]*fakeSession
+	counts   map[string]int
+	messages map[string][]string
+	names    []string
+	touched  map[string]time.Time
+	// 准备数据与断言使用的连接, 不计数也不经过钩子
+	// 单独加锁, 等待回复时服务端钩子仍需获取 mutex
+	connMutex sync.Mutex
+	conn      redis.Conn
+	password  string
+	// 服务器时间相对本地时间的偏移
+	offset time.Duration
+	// 模拟旧版本服务器不支持 CLIENT INFO
+	noClientInfo bool
+	// 模拟禁用 OBJECT 命令
+	noObject bool
+	// 命令执行前的钩子, 返回 fakeError 时回复错误, errDrop 时断开连接, errHang 时不再回复
+	hook func(cmd string, args []string) error
+}
+
+// 错误回复
+type fakeError string
+
+func (e fakeError) Error() string {
+	return string(e)
+}
+
+// 连接状态, 仅记录钩子需要的部分, 事务与数据库由 miniredis 维护
+type fakeSession struct {
+	db      int
+	name    string
+	multi   bool
+	aborted bool
+	// 连接已断开, 缓冲区中剩余的命令不再执行
+	dropped bool
+	// 事务中的通知, EXEC 时记录
+	published [][2]string
+}
+
+var (
+	// 钩子返回时断开连接, 不回复
+	errDrop = errors.New("drop connection")
+	// 钩子返回时不再回复, 直到服务器关闭
+	errHang = errors.New("hang connection")
+)
+
+const (
+	// 准备数据与断言使用的连接名称
+	HARNESS_NAME = "fakeredis:harness"
+)
+
+// 启动测试服务器, 测试结束时关闭
+func newFakeRedis(t testing.TB) *fakeRedis {
+	m, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeRedis{
+		Miniredis: m,
+		t:         t,
+		closing:   make(chan struct{}),
+		sessions:  make(map[*server.Peer]*fakeSession),
+		counts:    make(map[string]int),
+		messages:  make(map[string][]string),
+		touched:   make(map[string]time.Time),
+	}
+	m.Server().SetPreHook(s.preHook)
+	t.Cleanup(s.close)
+	return s
+}
+
+// 连接测试服务器的配置
+func (s *fakeRedis) config() utils.Config {
+	return utils.Config{
+		Delayer: utils.Delayer{
+			TimerInterval: 1000,
+			LogLevel:      "error",
+		},
+		Redis: utils.Redis{
+			Host:        s.Host(),
+			Port:        s.Port(),
+			MaxIdle:     10,
+			DialTimeout: 1,
+		},
+	}
+}
+
+// 在锁内修改服务器设置, 如 offset, noClientInfo
+func (s *fakeRedis) update(fn func()) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	fn()
+}
+
+// 设置钩子
+func (s *fakeRedis) setHook(hook func(cmd string, args []string) error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.hook = hook
+}
+
+// 要求认证, 已建立的连接需重新认证
+func (s *fakeRedis) requireAuth(password string) {
+	s.RequireAuth(password)
+	s.connMutex.Lock()
+	defer s.connMutex.Unlock()
+	s.password = password
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+// 关闭服务器及全部连接, 阻塞中的命令随之返回
+func (s *fakeRedis) close() {
+	s.closed.Do(func() {
+		close(s.closing)
+		s.connMutex.Lock()
+		if s.conn != nil {
+			s.conn.Close()
+		}
+		s.connMutex.Unlock()
+		s.Close()
+	})
+}
+
+// 连接状态, 不存在时创建
+func (s *fakeRedis) session(c *server.Peer) *fakeSession {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	session, ok := s.sessions[c]
+	if !ok {
+		session = &fakeSession{}
+		s.sessions[c] = session
+		c.OnDisconnect(func() {
+			s.mutex.Lock()
+			defer s.mutex.Unlock()
+			delete(s.sessions, c)
+		})
+	}
+	return session
+}
+
+// 命令执行前的钩子, 返回 true 时已回复, 不再由 miniredis 处理
+func (s *fakeRedis) preHook(c *server.Peer, cmd string, args ...string) bool {
+	s.mutex.Lock()
+	session := s.sessions[c]
+	if session != nil && session.name == HARNESS_NAME {
+		s.mutex.Unlock()
+		return false
+	}
+	// 准备数据的连接设置名称, 不计数也不经过钩子
+	if cmd == "CLIENT" && len(args) == 2 && strings.ToUpper(args[0]) == "SETNAME" && args[1] == HARNESS_NAME {
+		s.mutex.Unlock()
+		return s.client(c, args)
+	}
+	if session != nil && session.dropped {
+		s.mutex.Unlock()
+		return true
+	}
+	hook := s.hook
+	s.counts[cmd]++
+	offset := s.offset
+	s.mutex.Unlock()
+	if hook != nil {
+		if err := hook(cmd, args); err != nil {
+			switch err {
+			case errDrop:
+				s.drop(c)
+				return true
+			case errHang:
+				<-s.closing
+				s.drop(c)
+				return true
+			}
+			// 与 Redis 一致, 入队失败的事务在 EXEC 时放弃
+			if session != nil && session.multi && cmd != "EXEC" && cmd != "DISCARD" {
+				session.aborted = true
+			}
+			c.WriteError(err.Error())
+			return true
+		}
+	}
+	switch cmd {
+	case "MULTI":
+		session = s.session(c)
+		session.multi = true
+		session.aborted = false
+		session.published = nil
+	case "EXEC", "DISCARD":
+		if session == nil || !session.multi {
+			return false
+		}
+		aborted := session.aborted
+		published := session.published
+		session.multi = false
+		session.aborted = false
+		session.published = nil
+		if cmd == "EXEC" && aborted {
+			s.discard(c)
+			c.WriteError("EXECABORT Transaction discarded because of previous errors.")
+			return true
+		}
+		if cmd == "EXEC" {
+			s.mutex.Lock()
+			for _, message := range published {
+				s.messages[message[0]] = append(s.messages[message[0]], message[1])
+			}
+			s.mutex.Unlock()
+		}
+	case "PUBLISH":
+		if len(args) != 2 {
+			return false
+		}
+		if session != nil && session.multi {
+			session.published = append(session.published, [2]string{args[0], args[1]})
+			return false
+		}
+		s.mutex.Lock()
+		s.messages[args[0]] = append(s.messages[args[0]], args[1])
+		s.mutex.Unlock()
+	case "SELECT":
+		if len(args) == 1 {
+			if db, err := strconv.Atoi(args[0]); err == nil && db >= 0 && db < 16 {
+				s.session(c).db = db
+			}
+		}
+	case "TIME":
+		now := time.Now().Add(offset)
+		c.WriteLen(2)
+		c.WriteBulk(strconv.FormatInt(now.Unix(), 10))
+		c.WriteBulk(strconv.FormatInt(int64(now.Nanosecond()/1000), 10))
+		return true
+	case "CLIENT":
+		return s.client(c, args)
+	case "OBJECT":
+		return s.object(c, session, args)
+	}
+	return false
+}
+
+// 断开连接, 已读取的后续命令不再执行
+func (s *fakeRedis) drop(c *server.Peer) {
+	session := s.session(c)
+	s.mutex.Lock()
+	session.dropped = true
+	s.mutex.Unlock()
+	c.Close()
+}
+
+// 放弃 miniredis 中已入队的事务, 回复丢弃
+func (s *fakeRedis) discard(c *server.Peer) {
+	peer := server.NewPeer(bufio.NewWriter(ioutil.Discard))
+	peer.Ctx = c.Ctx
+	s.Server().Dispatch(peer, []string{"DISCARD"})
+}
+
+// CLIENT SETNAME 与 CLIENT INFO
+func (s *fakeRedis) client(c *server.Peer, args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	switch strings.ToUpper(args[0]) {
+	case "SETNAME":
+		if len(args) != 2 {
+			return false
+		}
+		session := s.session(c)
+		s.mutex.Lock()
+		session.name = args[1]
+		if args[1] != HARNESS_NAME {
+			s.names = append(s.names, args[1])
+		}
+		s.mutex.Unlock()
+		c.WriteOK()
+		return true
+	case "INFO":
+		s.mutex.Lock()
+		noClientInfo := s.noClientInfo
+		s.mutex.Unlock()
+		if noClientInfo {
+			c.WriteError("ERR Unknown subcommand or wrong number of arguments for 'INFO'. Try CLIENT HELP")
+			return true
+		}
+		session := s.session(c)
+		c.WriteBulk(fmt.Sprintf("id=1 addr=127.0.0.1:0 name=%s db=%d cmd=client\n", session.name, session.db))
+		return true
+	}
+	return false
+}
+
+// OBJECT IDLETIME, 未通过 setIdle 设置的键空闲时间为 0
+func (s *fakeRedis) object(c *server.Peer, session *fakeSession, args []string) bool {
+	s.mutex.Lock()
+	noObject := s.noObject
+	s.mutex.Unlock()
+	if noObject {
+		c.WriteError("ERR unknown command 'OBJECT'")
+		return true
+	}
+	if len(args) != 2 || strings.ToUpper(args[0]) != "IDLETIME" {
+		c.WriteError("ERR syntax error")
+		return true
+	}
+	db := 0
+	if session != nil {
+		db = session.db
+	}
+	if !s.DB(db).Exists(args[1]) {
+		c.WriteNull()
+		return true
+	}
+	s.mutex.Lock()
+	touched, ok := s.touched[args[1]]
+	s.mutex.Unlock()
+	if !ok {
+		touched = time.Now()
+	}
+	c.WriteInt(int(time.Since(touched) / time.Second))
+	return true
+}
+
+// 准备数据与断言使用的连接, 调用方持有 connMutex
+func (s *fakeRedis) harness() redis.Conn {
+	if s.conn != nil && s.conn.Err() == nil {
+		return s.conn
+	}
+	c, err := redis.Dial("tcp", s.Addr())
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	if _, err := c.Do("CLIENT", "SETNAME", HARNESS_NAME); err != nil {
+		s.t.Fatal(err)
+	}
+	if s.password != "" {
+		if _, err := c.Do("AUTH", s.password); err != nil {
+			s.t.Fatal(err)
+		}
+	}
+	s.conn = c
+	return c
+}
+
+// 写入一个任务: JobPool中的分数与Bucket的Topic
+func (s *fakeRedis) addJob(jobID string, topic string, fireAt int64) {
+	s.do("ZADD", KEY_JOB_POOL, strconv.FormatInt(fireAt, 10), jobID)
+	s.do("HSET", PREFIX_JOB_BUCKET+jobID, FIELD_TOPIC, topic)
+}
+
+// 执行命令并返回回复, 用于准备数据与断言, 批量字符串转换为 string, 数组转换为 []string
+func (s *fakeRedis) do(args ...string) interface{} {
+	return s.doIn(0, args...)
+}
+
+// 在指定数据库中执行命令
+func (s *fakeRedis) doIn(db int, args ...string) interface{} {
+	s.connMutex.Lock()
+	defer s.connMutex.Unlock()
+	c := s.harness()
+	if db != 0 {
+		c.Do("SELECT", db)
+		defer c.Do("SELECT", 0)
+	}
+	values := make([]interface{}, len(args)-1)
+	for i, arg := range args[1:] {
+		values[i] = arg
+	}
+	reply, err := c.Do(args[0], values...)
+	if err != nil {
+		return err
+	}
+	return plainReply(reply)
+}
+
+// 转换回复类型
+func plainReply(reply interface{}) interface{} {
+	switch v := reply.(type) {
+	case []byte:
+		return string(v)
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			b, ok := item.([]byte)
+			if !ok {
+				return v
+			}
+			items = append(items, string(b))
+		}
+		return items
+	}
+	return reply
+}
+
+// 列表内容, 按客户端 RPOP 的顺序返回, 即最先取出的在前
+func (s *fakeRedis) queue(topic string) []string {
+	return s.list(PREFIX_READY_QUEUE + topic)
+}
+
+// 列表内容, 按 RPOP 的顺序返回
+func (s *fakeRedis) list(key string) []string {
+	items, _ := s.do("LRANGE", key, "0", "-1").([]string)
+	ids := make([]string, 0, len(items))
+	for i := len(items) - 1; i >= 0; i-- {
+		ids = append(ids, items[i])
+	}
+	return ids
+}
+
+// 有序集合中的分数
+func (s *fakeRedis) score(key string, member string) (float64, bool) {
+	value, ok := s.do("ZSCORE", key, member).(string)
+	if !ok {
+		return 0, false
+	}
+	score, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return score, true
+}
+
+// Bucket字段
+func (s *fakeRedis) field(jobID string, field string) string {
+	value, _ := s.do("HGET", PREFIX_JOB_BUCKET+jobID, field).(string)
+	return value
+}
+
+// 频道收到的消息
+func (s *fakeRedis) published(channel string) []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.messages[channel]
+}
+
+// 命令执行次数, 不含准备数据与断言的命令
+func (s *fakeRedis) count(cmd string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.counts[cmd]
+}
+
+// 连接设置的名称, 按设置顺序
+func (s *fakeRedis) clientNames() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string(nil), s.names...)
+}
+
+// 将键的最近访问时间设为 d 之前
+func (s *fakeRedis) setIdle(key string, d time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.touched[key] = time.Now().Add(-d)
+}
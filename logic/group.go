@@ -0,0 +1,93 @@
+package logic
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// 移动任务的最大并发数
+	MAX_MOVE_CONCURRENCY = 64
+)
+
+// 并发执行组, 限制并发数
+type runGroup struct {
+	wg  sync.WaitGroup
+	sem chan bool
+}
+
+// 创建实例
+func newRunGroup(limit int) *runGroup {
+	return &runGroup{
+		sem: make(chan bool, limit),
+	}
+}
+
+// 执行
+func (p *runGroup) Go(fn func()) {
+	p.sem <- true
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer func() { <-p.sem }()
+		fn()
+	}()
+}
+
+// 等待全部完成
+func (p *runGroup) Wait() {
+	p.wg.Wait()
+}
+
+// 单次执行的错误汇总
+type TickError struct {
+	Total  int
+	Counts map[string]int
+	First  error
+	// 首个错误的分类
+	Category ErrorCategory
+	mutex    sync.Mutex
+}
+
+// 记录错误
+func (e *TickError) add(err error, funcName string, data string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.Counts == nil {
+		e.Counts = make(map[string]int)
+	}
+	if e.First == nil {
+		if data != "" {
+			data = ", [" + data + "]"
+		}
+		e.First = fmt.Errorf("func %s, %w%s", funcName, err, data)
+		e.Category = Categorize(err)
+	}
+	e.Counts[funcName]++
+	e.Total++
+}
+
+// 首个错误, 可通过 errors.Is/As 判断原始错误
+func (e *TickError) Unwrap() error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.First
+}
+
+// 错误信息
+func (e *TickError) Error() string {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	names := make([]string, 0, len(e.Counts))
+	for name := range e.Counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	counts := make([]string, 0, len(names))
+	for _, name := range names {
+		counts = append(counts, fmt.Sprintf("%s x%d", name, e.Counts[name]))
+	}
+	return fmt.Sprintf("%d errors in tick (%s), first: %s", e.Total, strings.Join(counts, ", "), e.First.Error())
+}
@@ -0,0 +1,124 @@
+package logic
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dcsunny/delayer/utils"
+)
+
+func TestTickErrorsAggregated(t *testing.T) {
+	s := newFakeRedis(t)
+	now := time.Now().Unix()
+	for _, topic := range []string{"bad-1", "bad-2", "bad-3", "good"} {
+		s.addJob(topic+"-job", topic, now-1)
+	}
+	s.setHook(func(cmd string, args []string) error {
+		if cmd == "LPUSH" && strings.HasPrefix(args[0], PREFIX_READY_QUEUE+"bad-") {
+			return errDrop
+		}
+		return nil
+	})
+	timer := newTestTimer(t, s, nil)
+	var mutex sync.Mutex
+	var handled []string
+	timer.HandleError = func(err error, funcName string, data string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		handled = append(handled, funcName)
+	}
+	var tickErr error
+	timer.OnTick = func(err error) {
+		tickErr = err
+	}
+	timer.tick()
+	// 汇总后只处理一次
+	if len(handled) != 1 || handled[0] != "run" {
+		t.Fatalf("expected a single aggregated error, got %v", handled)
+	}
+	errs, ok := tickErr.(*TickError)
+	if !ok {
+		t.Fatalf("expected *TickError, got %T", tickErr)
+	}
+	if errs.Total != 3 {
+		t.Fatalf("expected 3 errors, got %d: %s", errs.Total, errs.Error())
+	}
+	if !strings.Contains(errs.Error(), "3 errors in tick") {
+		t.Fatalf("unexpected message %q", errs.Error())
+	}
+	assertQueue(t, s, "good", "good-job")
+	// 成功的执行回调 nil
+	s.setHook(nil)
+	timer.tick()
+	if tickErr != nil {
+		t.Fatalf("expected a successful tick, got %v", tickErr)
+	}
+}
+
+func TestRunGroupLimit(t *testing.T) {
+	g := newRunGroup(2)
+	var mutex sync.Mutex
+	running, peak := 0, 0
+	for i := 0; i < 8; i++ {
+		g.Go(func() {
+			mutex.Lock()
+			running++
+			if running > peak {
+				peak = running
+			}
+			mutex.Unlock()
+			time.Sleep(5 * time.Millisecond)
+			mutex.Lock()
+			running--
+			mutex.Unlock()
+		})
+	}
+	g.Wait()
+	if peak > 2 {
+		t.Fatalf("expected at most 2 concurrent runs, got %d", peak)
+	}
+}
+
+func TestGroupByCustomField(t *testing.T) {
+	s := newFakeRedis(t)
+	now := time.Now().Unix()
+	for _, job := range []struct {
+		id     string
+		topic  string
+		tenant string
+		fireAt int64
+	}{
+		{"a", "mail", "t1", now - 3},
+		{"b", "mail", "t2", now - 2},
+		{"c", "sms", "t1", now - 1},
+	} {
+		s.addJob(job.id, job.topic, job.fireAt)
+		s.do("HSET", PREFIX_JOB_BUCKET+job.id, "tenant", job.tenant)
+	}
+	configure := func(config *utils.Config) {
+		config.Delayer.GroupField = "tenant"
+	}
+	config := s.config()
+	configure(&config)
+	admin, err := NewAdmin(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Pool.Close()
+	// 管理接口按相同字段匹配
+	jobs, err := admin.ListPending("t1", 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 2 || jobs[0].ID != "a" || jobs[1].ID != "c" {
+		t.Fatalf("expected jobs a and c pending for t1, got %v", jobs)
+	}
+	timer := newTestTimer(t, s, configure)
+	timer.tick()
+	assertQueue(t, s, "t1", "a", "c")
+	assertQueue(t, s, "t2", "b")
+	assertQueue(t, s, "mail")
+	assertQueue(t, s, "sms")
+}
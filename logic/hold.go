@@ -0,0 +1,121 @@
+package logic
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// 暂停Topic, 定时器不再移动该Topic的任务, 到期的任务移至暂存集合并保留原就绪时间, 恢复后放回JobPool
+func (p *Admin) HoldTopic(topic string) error {
+	conn := p.Pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("SADD", p.keys().HeldTopics, topic)
+	return err
+}
+
+// 恢复Topic, 将暂存的任务按原就绪时间放回JobPool, 返回该Topic是否处于暂停中
+func (p *Admin) ReleaseTopic(topic string) (bool, error) {
+	conn := p.Pool.Get()
+	defer conn.Close()
+	keys := p.keys()
+	held, err := redis.Bool(conn.Do("SREM", keys.HeldTopics, topic))
+	if err != nil {
+		return false, err
+	}
+	// 定时器暂存时监视 HeldTopics, 移除后不会再有新的任务暂存
+	for {
+		values, err := redis.Values(conn.Do("ZRANGE", keys.HeldPool+topic, 0, SCAN_BATCH_SIZE-1, "WITHSCORES"))
+		if err != nil {
+			return held, err
+		}
+		if len(values) == 0 {
+			return held, nil
+		}
+		zadd := redis.Args{}.Add(keys.JobPool)
+		zrem := redis.Args{}.Add(keys.HeldPool + topic)
+		for i := 0; i+1 < len(values); i += 2 {
+			zadd = zadd.Add(values[i+1], values[i])
+			zrem = zrem.Add(values[i])
+		}
+		conn.Send("MULTI")
+		conn.Send("ZADD", zadd...)
+		conn.Send("ZREM", zrem...)
+		if _, err := conn.Do("EXEC"); err != nil {
+			return held, err
+		}
+	}
+}
+
+// 获取暂停中的Topic
+func (p *Admin) HeldTopics() ([]string, error) {
+	conn := p.Pool.Get()
+	defer conn.Close()
+	topics, err := redis.Strings(conn.Do("SMEMBERS", p.keys().HeldTopics))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(topics)
+	return topics, nil
+}
+
+// 获取暂停中的Topic, 失败时不暂停任何Topic
+func (p *Timer) heldTopics() map[string]bool {
+	conn := p.pool().Get()
+	defer conn.Close()
+	topics, err := redis.Strings(conn.Do("SMEMBERS", p.keys.HeldTopics))
+	if err != nil {
+		p.fail(err, "heldTopics", "")
+		return nil
+	}
+	held := make(map[string]bool, len(topics))
+	for _, topic := range topics {
+		held[topic] = true
+	}
+	return held
+}
+
+// 将暂停Topic的任务移至暂存集合, 监视 HeldTopics 以免与恢复交错
+func (p *Timer) holdJobs(topic string, jobIDs []string, scores map[string]int64) {
+	conn := p.pool().Get()
+	defer conn.Close()
+	jobIDsStr := strings.Join(jobIDs, ",")
+	if _, err := conn.Do("WATCH", p.keys.HeldTopics); err != nil {
+		p.fail(err, "holdJobs", jobIDsStr)
+		return
+	}
+	defer conn.Do("UNWATCH")
+	held, err := redis.Bool(conn.Do("SISMEMBER", p.keys.HeldTopics, topic))
+	if err != nil {
+		p.fail(err, "holdJobs", jobIDsStr)
+		return
+	}
+	// 已恢复, 下次执行时移动
+	if !held {
+		return
+	}
+	// 仅暂存仍在JobPool中的任务
+	pending, err := p.pendingJobs(conn, jobIDs)
+	if err != nil {
+		p.fail(err, "holdJobs", jobIDsStr)
+		return
+	}
+	if len(pending) == 0 {
+		return
+	}
+	zadd := redis.Args{}.Add(p.keys.HeldPool + topic)
+	for _, jobID := range pending {
+		zadd = zadd.Add(scores[jobID], jobID)
+	}
+	conn.Send("MULTI")
+	conn.Send("ZREM", redis.Args{}.Add(p.keys.JobPool).AddFlat(pending)...)
+	conn.Send("ZADD", zadd...)
+	if _, err := conn.Do("EXEC"); err != nil && err != redis.ErrNil {
+		p.fail(err, "holdJobs", jobIDsStr)
+		return
+	}
+	if p.topicCache != nil {
+		p.topicCache.remove(pending...)
+	}
+}
@@ -0,0 +1,136 @@
+package logic
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestHeldTopicJobsFlowAfterRelease(t *testing.T) {
+	s := newFakeRedis(t)
+	fireAt := time.Now().Unix() - 1
+	s.addJob("a", "mail", fireAt)
+	s.addJob("b", "sms", fireAt)
+	admin := newTestAdmin(t, s)
+	if err := admin.HoldTopic("mail"); err != nil {
+		t.Fatal(err)
+	}
+	topics, err := admin.HeldTopics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(topics, []string{"mail"}) {
+		t.Fatalf("expected [mail] to be held, got %v", topics)
+	}
+	timer := newTestTimer(t, s, nil)
+	timer.tick()
+	// 暂停的Topic任务暂存, 保留原就绪时间, 其他Topic不受影响
+	assertQueue(t, s, "mail")
+	assertQueue(t, s, "sms", "b")
+	score, ok := s.score(PREFIX_HELD_POOL+"mail", "a")
+	if !ok || int64(score) != fireAt {
+		t.Fatalf("expected job a held with score %d, got %v (%v)", fireAt, score, ok)
+	}
+	if _, ok := s.score(KEY_JOB_POOL, "a"); ok {
+		t.Fatal("held job is still in the pool")
+	}
+	held, err := admin.ReleaseTopic("mail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !held {
+		t.Fatal("expected mail to have been held")
+	}
+	if score, ok := s.score(KEY_JOB_POOL, "a"); !ok || int64(score) != fireAt {
+		t.Fatalf("expected job a back in the pool with score %d, got %v (%v)", fireAt, score, ok)
+	}
+	timer.tick()
+	assertQueue(t, s, "mail", "a")
+}
+
+func TestReleaseTopicNotHeld(t *testing.T) {
+	s := newFakeRedis(t)
+	admin := newTestAdmin(t, s)
+	held, err := admin.ReleaseTopic("mail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if held {
+		t.Fatal("expected mail not to be held")
+	}
+}
+
+// 写入暂停时暂存与移动失败后暂存的任务
+func addParkedJobs(s *fakeRedis, now int64) {
+	s.do("HSET", PREFIX_JOB_BUCKET+"held", FIELD_TOPIC, "mail")
+	s.do("ZADD", PREFIX_HELD_POOL+"mail", strconv.FormatInt(now-30, 10), "held")
+	s.do("SADD", KEY_HELD_TOPICS, "mail")
+	s.do("HSET", PREFIX_JOB_BUCKET+"retry", FIELD_TOPIC, "mail")
+	s.do("ZADD", KEY_RETRY_POOL, strconv.FormatInt(now+10, 10), "retry")
+}
+
+func TestAdminSeesParkedJobs(t *testing.T) {
+	s := newFakeRedis(t)
+	now := time.Now().Unix()
+	s.addJob("pending", "mail", now-20)
+	addParkedJobs(s, now)
+	admin := newTestAdmin(t, s)
+	jobs, err := admin.ListPending("mail", 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, job := range jobs {
+		ids = append(ids, job.ID)
+	}
+	if !reflect.DeepEqual(ids, []string{"held", "pending", "retry"}) {
+		t.Fatalf("expected parked jobs in score order, got %v", ids)
+	}
+	if n, err := admin.CountOverdue(); err != nil || n != 3 {
+		t.Fatalf("expected 3 overdue jobs, got %d (%v)", n, err)
+	}
+	if n, err := admin.CountDueWithin(0); err != nil || n != 2 {
+		t.Fatalf("expected 2 jobs due now, got %d (%v)", n, err)
+	}
+	if n, err := admin.CountDueWithin(time.Minute); err != nil || n != 3 {
+		t.Fatalf("expected 3 jobs due within a minute, got %d (%v)", n, err)
+	}
+	n, err := admin.DelayTopic("mail", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Fatalf("expected 3 delayed jobs, got %d", n)
+	}
+	if score, _ := s.score(PREFIX_HELD_POOL+"mail", "held"); int64(score) != now-30+3600 {
+		t.Fatalf("expected the held job to be delayed, got %d", int64(score))
+	}
+	if score, _ := s.score(KEY_RETRY_POOL, "retry"); int64(score) != now+10+3600 {
+		t.Fatalf("expected the retry job to be delayed, got %d", int64(score))
+	}
+}
+
+func TestCancelParkedJobs(t *testing.T) {
+	s := newFakeRedis(t)
+	addParkedJobs(s, time.Now().Unix())
+	admin := newTestAdmin(t, s)
+	for _, c := range []struct {
+		id  string
+		key string
+	}{
+		{"held", PREFIX_HELD_POOL + "mail"},
+		{"retry", KEY_RETRY_POOL},
+	} {
+		ok, err := admin.Cancel(c.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			t.Fatalf("expected job %s to exist", c.id)
+		}
+		if _, ok := s.score(c.key, c.id); ok {
+			t.Fatalf("cancelled job %s is still in %s", c.id, c.key)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package logic
+
+import (
+	"strings"
+	"time"
+
+	"github.com/dcsunny/delayer/utils"
+)
+
+// Bucket 字段约定:
+// v       结构版本, 缺失视为 0 (早期未带版本的记录)
+// topic   所属Topic, 各版本均存在
+// retries 重试次数, v1 起写入, 缺失视为 0
+// base_time, offset 基准时间与偏移量 (秒), 均存在时就绪时间为两者之和
+// ready_at 进入ReadyQueue的时间 (秒), 开启 queue_age_threshold 时由定时器写入
+// meta:*  元数据, 以前缀区分, 不会覆盖上述保留字段
+// 读取方需忽略未知字段, 新增字段缺失时使用默认值, 以便新旧版本共存
+const (
+	BUCKET_VERSION = 1
+	FIELD_VERSION  = "v"
+	FIELD_TOPIC    = "topic"
+	FIELD_RETRIES  = "retries"
+	PREFIX_META    = "meta:"
+	FIELD_BASE     = "base_time"
+	FIELD_OFFSET   = "offset"
+)
+
+// 任务数据
+type Job struct {
+	ID      string            `json:"id"`
+	Topic   string            `json:"topic"`
+	Version int               `json:"v"`
+	Retries int               `json:"retries"`
+	Fields  map[string]string `json:"fields"`
+	Meta    map[string]string `json:"meta"`
+	// 距离就绪的剩余时间, 仅在JobPool中的任务有效
+	Delay time.Duration `json:"delay"`
+}
+
+// 从 bucket 字段构建任务
+func newJob(jobID string, fields map[string]string) *Job {
+	return &Job{
+		ID:      jobID,
+		Topic:   fields[FIELD_TOPIC],
+		Version: fieldInt(fields, FIELD_VERSION, 0),
+		Retries: fieldInt(fields, FIELD_RETRIES, 0),
+		Fields:  fields,
+		Meta:    fieldMeta(fields),
+	}
+}
+
+// 读取元数据字段
+func fieldMeta(fields map[string]string) map[string]string {
+	meta := make(map[string]string)
+	for k, v := range fields {
+		if strings.HasPrefix(k, PREFIX_META) {
+			meta[strings.TrimPrefix(k, PREFIX_META)] = v
+		}
+	}
+	return meta
+}
+
+// 元数据转换为 bucket 字段
+func (p *Job) metaFields() map[string]string {
+	fields := make(map[string]string, len(p.Meta))
+	for k, v := range p.Meta {
+		fields[PREFIX_META+k] = v
+	}
+	return fields
+}
+
+// 读取整型字段, 缺失或无效时返回默认值
+func fieldInt(fields map[string]string, name string, def int) int {
+	value, ok := fields[name]
+	if !ok {
+		return def
+	}
+	i, err := utils.StringToInt(value)
+	if err != nil {
+		return def
+	}
+	return i
+}
@@ -0,0 +1,47 @@
+package logic
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBucketVersions(t *testing.T) {
+	s := newFakeRedis(t)
+	// 早期未带版本的记录
+	s.do("HSET", PREFIX_JOB_BUCKET+"v0", FIELD_TOPIC, "mail")
+	s.do("HSET", PREFIX_JOB_BUCKET+"v1", FIELD_VERSION, "1", FIELD_TOPIC, "mail", FIELD_RETRIES, "2", PREFIX_META+"owner", "ops")
+	// 更新版本写入的未知字段不影响读取
+	s.do("HSET", PREFIX_JOB_BUCKET+"v2", FIELD_VERSION, "2", FIELD_TOPIC, "mail", FIELD_RETRIES, "1", "compression", "gzip")
+	admin := newTestAdmin(t, s)
+	for _, c := range []struct {
+		id      string
+		version int
+		retries int
+		meta    map[string]string
+	}{
+		{"v0", 0, 0, map[string]string{}},
+		{"v1", 1, 2, map[string]string{"owner": "ops"}},
+		{"v2", 2, 1, map[string]string{}},
+	} {
+		job, err := admin.Inspect(c.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job == nil {
+			t.Fatalf("job %s not found", c.id)
+		}
+		if job.Topic != "mail" || job.Version != c.version || job.Retries != c.retries {
+			t.Errorf("job %s: unexpected topic %q, version %d, retries %d", c.id, job.Topic, job.Version, job.Retries)
+		}
+		if !reflect.DeepEqual(job.Meta, c.meta) {
+			t.Errorf("job %s: expected meta %v, got %v", c.id, c.meta, job.Meta)
+		}
+	}
+}
+
+func TestBucketInvalidFieldsDefault(t *testing.T) {
+	job := newJob("a", map[string]string{FIELD_TOPIC: "mail", FIELD_VERSION: "x", FIELD_RETRIES: ""})
+	if job.Version != 0 || job.Retries != 0 {
+		t.Fatalf("expected invalid fields to read as 0, got version %d, retries %d", job.Version, job.Retries)
+	}
+}
@@ -0,0 +1,57 @@
+package logic
+
+import (
+	"strings"
+)
+
+const (
+	DEFAULT_NAMESPACE = "delayer"
+)
+
+// 键名
+type Keys struct {
+	JobPool       string
+	RetryPool     string
+	JobBucket     string
+	ReadyQueue    string
+	DeadQueue     string
+	NotifyChannel string
+	Quarantine    string
+	HeldTopics    string
+	HeldPool      string
+	Topics        string
+}
+
+// 按命名空间生成键名, 未配置时使用默认的 delayer
+func NewKeys(namespace string) Keys {
+	keys := Keys{
+		JobPool:       KEY_JOB_POOL,
+		RetryPool:     KEY_RETRY_POOL,
+		JobBucket:     PREFIX_JOB_BUCKET,
+		ReadyQueue:    PREFIX_READY_QUEUE,
+		DeadQueue:     PREFIX_DEAD_QUEUE,
+		NotifyChannel: PREFIX_NOTIFY_CHANNEL,
+		Quarantine:    KEY_QUARANTINE,
+		HeldTopics:    KEY_HELD_TOPICS,
+		HeldPool:      PREFIX_HELD_POOL,
+		Topics:        KEY_TOPICS,
+	}
+	if namespace == "" || namespace == DEFAULT_NAMESPACE {
+		return keys
+	}
+	rename := func(key string) string {
+		return namespace + strings.TrimPrefix(key, DEFAULT_NAMESPACE)
+	}
+	return Keys{
+		JobPool:       rename(keys.JobPool),
+		RetryPool:     rename(keys.RetryPool),
+		JobBucket:     rename(keys.JobBucket),
+		ReadyQueue:    rename(keys.ReadyQueue),
+		DeadQueue:     rename(keys.DeadQueue),
+		NotifyChannel: rename(keys.NotifyChannel),
+		Quarantine:    rename(keys.Quarantine),
+		HeldTopics:    rename(keys.HeldTopics),
+		HeldPool:      rename(keys.HeldPool),
+		Topics:        rename(keys.Topics),
+	}
+}
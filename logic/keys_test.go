@@ -0,0 +1,78 @@
+package logic
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/dcsunny/delayer/utils"
+)
+
+// 在指定数据库中写入命名空间下的任务
+func addJobIn(s *fakeRedis, db int, keys Keys, jobID string, topic string, fireAt int64) {
+	s.doIn(db, "ZADD", keys.JobPool, strconv.FormatInt(fireAt, 10), jobID)
+	s.doIn(db, "HSET", keys.JobBucket+jobID, FIELD_TOPIC, topic)
+}
+
+// 指定数据库中的ReadyQueue内容, 按 RPOP 的顺序返回
+func queueIn(s *fakeRedis, db int, keys Keys, topic string) []string {
+	items, _ := s.doIn(db, "LRANGE", keys.ReadyQueue+topic, "0", "-1").([]string)
+	for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+		items[i], items[j] = items[j], items[i]
+	}
+	return items
+}
+
+func TestNewKeysNamespace(t *testing.T) {
+	if keys := NewKeys(""); keys != NewKeys(DEFAULT_NAMESPACE) || keys.JobPool != KEY_JOB_POOL {
+		t.Fatalf("expected default keys, got %+v", keys)
+	}
+	keys := NewKeys("app")
+	if keys.JobPool != "app:job_pool" || keys.ReadyQueue != "app:ready_queue:" || keys.HeldPool != "app:held_pool:" {
+		t.Fatalf("unexpected namespaced keys %+v", keys)
+	}
+}
+
+func TestNamespacesOnSeparateDatabases(t *testing.T) {
+	s := newFakeRedis(t)
+	now := time.Now().Unix()
+	namespaces := []struct {
+		name string
+		db   int
+	}{
+		{"first", 1},
+		{"second", 2},
+	}
+	timers := make([]*Timer, len(namespaces))
+	for i, ns := range namespaces {
+		ns := ns
+		keys := NewKeys(ns.name)
+		addJobIn(s, ns.db, keys, ns.name+"-job", "mail", now-1)
+		// 另一数据库中的同名键不属于该命名空间
+		addJobIn(s, 3-ns.db, keys, ns.name+"-stray", "mail", now-1)
+		timers[i] = newTestTimer(t, s, func(config *utils.Config) {
+			config.Delayer.Namespace = ns.name
+			config.Delayer.NamespaceDatabase = &ns.db
+		})
+	}
+	for _, timer := range timers {
+		timer.tick()
+	}
+	for _, ns := range namespaces {
+		keys := NewKeys(ns.name)
+		if ids := queueIn(s, ns.db, keys, "mail"); !reflect.DeepEqual(ids, []string{ns.name + "-job"}) {
+			t.Fatalf("%s: expected only its own job moved in db %d, got %v", ns.name, ns.db, ids)
+		}
+		if ids := queueIn(s, 3-ns.db, keys, "mail"); len(ids) != 0 {
+			t.Fatalf("%s: expected nothing moved in db %d, got %v", ns.name, 3-ns.db, ids)
+		}
+		if n, _ := s.doIn(3-ns.db, "ZCARD", keys.JobPool).(int64); n != 1 {
+			t.Fatalf("%s: expected the stray job left in db %d, got %d", ns.name, 3-ns.db, n)
+		}
+	}
+	// 默认数据库不受影响
+	if keys, _ := s.doIn(0, "KEYS", "*").([]string); len(keys) != 0 {
+		t.Fatalf("expected db 0 untouched, got %v", keys)
+	}
+}
@@ -0,0 +1,70 @@
+package logic
+
+import (
+	"container/list"
+	"sync"
+)
+
+// 任务Topic缓存 (LRU)
+type topicCache struct {
+	mutex sync.Mutex
+	size  int
+	items map[string]*list.Element
+	order *list.List
+}
+
+// 缓存项
+type topicCacheItem struct {
+	jobID string
+	topic string
+}
+
+// 创建实例
+func newTopicCache(size int) *topicCache {
+	return &topicCache{
+		size:  size,
+		items: make(map[string]*list.Element),
+		order: list.New(),
+	}
+}
+
+// 获取
+func (p *topicCache) get(jobID string) (string, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	e, ok := p.items[jobID]
+	if !ok {
+		return "", false
+	}
+	p.order.MoveToFront(e)
+	return e.Value.(*topicCacheItem).topic, true
+}
+
+// 写入
+func (p *topicCache) add(jobID string, topic string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if e, ok := p.items[jobID]; ok {
+		e.Value.(*topicCacheItem).topic = topic
+		p.order.MoveToFront(e)
+		return
+	}
+	p.items[jobID] = p.order.PushFront(&topicCacheItem{jobID: jobID, topic: topic})
+	if p.order.Len() > p.size {
+		e := p.order.Back()
+		p.order.Remove(e)
+		delete(p.items, e.Value.(*topicCacheItem).jobID)
+	}
+}
+
+// 删除
+func (p *topicCache) remove(jobIDs ...string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, jobID := range jobIDs {
+		if e, ok := p.items[jobID]; ok {
+			p.order.Remove(e)
+			delete(p.items, jobID)
+		}
+	}
+}
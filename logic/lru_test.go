@@ -0,0 +1,56 @@
+package logic
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dcsunny/delayer/utils"
+)
+
+func TestTopicCacheSkipsLookup(t *testing.T) {
+	s := newFakeRedis(t)
+	s.addJob("heartbeat", "mail", time.Now().Unix()+60)
+	timer := newTestTimer(t, s, func(config *utils.Config) {
+		config.Delayer.TopicCacheSize = 8
+	})
+	ch := make(chan []string, 2)
+	timer.getJobTopic("heartbeat", ch)
+	timer.getJobTopic("heartbeat", ch)
+	for i := 0; i < 2; i++ {
+		if arr := <-ch; arr[1] != "mail" {
+			t.Fatalf("expected topic mail, got %q", arr[1])
+		}
+	}
+	if n := s.count("HMGET"); n != 1 {
+		t.Fatalf("expected 1 HMGET, got %d", n)
+	}
+}
+
+func TestTopicCacheInvalidatedOnMove(t *testing.T) {
+	s := newFakeRedis(t)
+	s.addJob("a", "mail", time.Now().Unix()-1)
+	timer := newTestTimer(t, s, func(config *utils.Config) {
+		config.Delayer.TopicCacheSize = 8
+	})
+	timer.tick()
+	assertQueue(t, s, "mail", "a")
+	if _, ok := timer.topicCache.get("a"); ok {
+		t.Fatal("moved job is still cached")
+	}
+}
+
+func TestTopicCacheEvictsOldest(t *testing.T) {
+	cache := newTopicCache(2)
+	cache.add("a", "mail")
+	cache.add("b", "mail")
+	cache.get("a")
+	cache.add("c", "sms")
+	if _, ok := cache.get("b"); ok {
+		t.Fatal("expected least recently used entry to be evicted")
+	}
+	for _, jobID := range []string{"a", "c"} {
+		if _, ok := cache.get(jobID); !ok {
+			t.Fatalf("expected %s to be cached", jobID)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package logic
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// 按就绪时间排序任务, 相同时按ID排序
+func sortJobIDs(jobIDs []string, scores map[string]int64) {
+	sort.Slice(jobIDs, func(i, j int) bool {
+		if scores[jobIDs[i]] != scores[jobIDs[j]] {
+			return scores[jobIDs[i]] < scores[jobIDs[j]]
+		}
+		return jobIDs[i] < jobIDs[j]
+	})
+}
+
+// 排序后的Topic列表
+func sortedTopics(topics map[string][]string) []string {
+	names := make([]string, 0, len(topics))
+	for topic := range topics {
+		names = append(names, topic)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// 单次执行中移动完成的任务, 各Topic并发移动, 全部结束后按Topic顺序回调, 使处理顺序稳定
+type readyBatch struct {
+	mutex sync.Mutex
+	moves []readyMove
+}
+
+// 移动完成的一批任务
+type readyMove struct {
+	topic  string
+	jobIDs []string
+	scores map[string]int64
+	at     time.Time
+}
+
+// 记录
+func (b *readyBatch) add(move readyMove) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.moves = append(b.moves, move)
+}
+
+// 按Topic排序, 同一Topic保持移动顺序
+func (b *readyBatch) sorted() []readyMove {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	moves := append([]readyMove(nil), b.moves...)
+	sort.SliceStable(moves, func(i, j int) bool {
+		return moves[i].topic < moves[j].topic
+	})
+	return moves
+}
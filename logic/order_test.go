@@ -0,0 +1,107 @@
+package logic
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dcsunny/delayer/utils"
+)
+
+func TestReadyOrderStable(t *testing.T) {
+	var first []string
+	for run := 0; run < 5; run++ {
+		s := newFakeRedis(t)
+		now := time.Now().Unix()
+		// 写入顺序与Topic顺序, 就绪时间顺序均不同
+		for i := 9; i >= 0; i-- {
+			topic := fmt.Sprintf("t%d", i)
+			s.addJob(topic+"-b", topic, now-1)
+			s.addJob(topic+"-a", topic, now-1)
+			s.addJob(topic+"-c", topic, now-2)
+		}
+		timer := newTestTimer(t, s, func(config *utils.Config) {
+			config.Redis.MaxActive = 40
+		})
+		var order []string
+		timer.OnJobReady = func(topic string, ids []string) {
+			order = append(order, topic+":"+fmt.Sprint(ids))
+		}
+		timer.tick()
+		if len(order) != 10 {
+			t.Fatalf("expected 10 ready callbacks, got %v", order)
+		}
+		if order[0] != "t0:[t0-c t0-a t0-b]" {
+			t.Fatalf("expected topics and ids in order, got %v", order)
+		}
+		if first == nil {
+			first = order
+			continue
+		}
+		if !reflect.DeepEqual(order, first) {
+			t.Fatalf("run %d: expected order %v, got %v", run, first, order)
+		}
+	}
+}
+
+// 并发移动的吞吐量, 对比 MaxActive 为 2 时即串行移动
+func BenchmarkExecute(b *testing.B) {
+	for _, maxActive := range []int{2, 40} {
+		maxActive := maxActive
+		b.Run(fmt.Sprintf("max_active=%d", maxActive), func(b *testing.B) {
+			s := newFakeRedis(b)
+			timer := newTestTimer(b, s, func(config *utils.Config) {
+				config.Redis.MaxActive = maxActive
+				config.Redis.MaxIdle = maxActive
+			})
+			now := time.Now().Unix()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				for j := 0; j < 100; j++ {
+					s.addJob(fmt.Sprintf("%d-%d", i, j), fmt.Sprintf("t%d", j%20), now-1)
+				}
+				b.StartTimer()
+				timer.execute()
+			}
+		})
+	}
+}
+
+func TestReadyOrderDirection(t *testing.T) {
+	for _, c := range []struct {
+		order    string
+		expected []string
+	}{
+		{"", []string{"a", "b", "c"}},
+		{utils.READY_ORDER_FIFO, []string{"a", "b", "c"}},
+		{utils.READY_ORDER_LIFO, []string{"c", "b", "a"}},
+	} {
+		c := c
+		t.Run("order="+c.order, func(t *testing.T) {
+			s := newFakeRedis(t)
+			now := time.Now().Unix()
+			s.addJob("a", "mail", now-3)
+			s.addJob("b", "mail", now-2)
+			timer := newTestTimer(t, s, func(config *utils.Config) {
+				config.Delayer.ReadyOrder = c.order
+			})
+			timer.tick()
+			s.addJob("c", "mail", now-1)
+			timer.tick()
+			// 客户端从右侧取出
+			var popped []string
+			for {
+				jobID, ok := s.do("RPOP", PREFIX_READY_QUEUE+"mail").(string)
+				if !ok {
+					break
+				}
+				popped = append(popped, jobID)
+			}
+			if !reflect.DeepEqual(popped, c.expected) {
+				t.Fatalf("expected jobs popped in order %v, got %v", c.expected, popped)
+			}
+		})
+	}
+}
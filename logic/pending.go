@@ -0,0 +1,138 @@
+package logic
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// 等待中的任务所在集合的来源名称, 不含命名空间, 可导入至其他命名空间
+const (
+	SOURCE_JOB_POOL    = "job_pool"
+	SOURCE_RETRY_POOL  = "retry_pool"
+	SOURCE_QUARANTINE  = "quarantine"
+	PREFIX_SOURCE_HELD = "held_pool:"
+)
+
+// 等待中的任务所在的有序集合
+type pendingSet struct {
+	Source string
+	Key    string
+}
+
+// 全部等待中的任务集合: JobPool, RetryPool, 各Topic的暂存集合与隔离集合, 暂存集合通过 SCAN 查找, 恢复后未清空的也包含在内
+func (p *Admin) pendingSets(conn redis.Conn) ([]pendingSet, error) {
+	keys := p.keys()
+	sets := []pendingSet{
+		{Source: SOURCE_JOB_POOL, Key: keys.JobPool},
+		{Source: SOURCE_RETRY_POOL, Key: keys.RetryPool},
+	}
+	var held []string
+	cursor := "0"
+	for {
+		values, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", keys.HeldPool+"*", "COUNT", SCAN_BATCH_SIZE))
+		if err != nil {
+			return nil, err
+		}
+		cursor, _ = redis.String(values[0], nil)
+		found, _ := redis.Strings(values[1], nil)
+		held = append(held, found...)
+		if cursor == "0" {
+			break
+		}
+	}
+	sort.Strings(held)
+	for _, key := range held {
+		sets = append(sets, pendingSet{Source: PREFIX_SOURCE_HELD + strings.TrimPrefix(key, keys.HeldPool), Key: key})
+	}
+	return append(sets, pendingSet{Source: SOURCE_QUARANTINE, Key: keys.Quarantine}), nil
+}
+
+// 来源对应的键, 未知来源返回 false
+func (p *Admin) sourceKey(source string) (string, bool) {
+	keys := p.keys()
+	switch {
+	case source == SOURCE_JOB_POOL:
+		return keys.JobPool, true
+	case source == SOURCE_RETRY_POOL:
+		return keys.RetryPool, true
+	case source == SOURCE_QUARANTINE:
+		return keys.Quarantine, true
+	case strings.HasPrefix(source, PREFIX_SOURCE_HELD) && len(source) > len(PREFIX_SOURCE_HELD):
+		return keys.HeldPool + strings.TrimPrefix(source, PREFIX_SOURCE_HELD), true
+	}
+	return "", false
+}
+
+// 有序集合中的任务
+type scoredJob struct {
+	ID    string
+	Score int64
+	// 所在集合的键
+	Key string
+}
+
+// 按分数升序合并遍历多个有序集合, 逐批读取, 分数相同时按集合顺序与任务ID排序
+type pendingMerger struct {
+	conn    redis.Conn
+	keys    []string
+	offsets []int
+	batches [][]scoredJob
+	done    []bool
+}
+
+func newPendingMerger(conn redis.Conn, keys ...string) *pendingMerger {
+	return &pendingMerger{
+		conn:    conn,
+		keys:    keys,
+		offsets: make([]int, len(keys)),
+		batches: make([][]scoredJob, len(keys)),
+		done:    make([]bool, len(keys)),
+	}
+}
+
+// 读取后续至多 n 个任务, 已遍历完时返回空
+func (m *pendingMerger) next(n int) ([]scoredJob, error) {
+	var jobs []scoredJob
+	for len(jobs) < n {
+		min := -1
+		for i := range m.keys {
+			if len(m.batches[i]) == 0 && !m.done[i] {
+				if err := m.fill(i); err != nil {
+					return nil, err
+				}
+			}
+			if len(m.batches[i]) == 0 {
+				continue
+			}
+			if min < 0 || m.batches[i][0].Score < m.batches[min][0].Score {
+				min = i
+			}
+		}
+		if min < 0 {
+			break
+		}
+		jobs = append(jobs, m.batches[min][0])
+		m.batches[min] = m.batches[min][1:]
+	}
+	return jobs, nil
+}
+
+// 读取集合的下一批
+func (m *pendingMerger) fill(i int) error {
+	values, err := redis.Values(m.conn.Do("ZRANGE", m.keys[i], m.offsets[i], m.offsets[i]+SCAN_BATCH_SIZE-1, "WITHSCORES"))
+	if err != nil {
+		return err
+	}
+	for j := 0; j+1 < len(values); j += 2 {
+		jobID, _ := redis.String(values[j], nil)
+		score, _ := scoreInt64(values[j+1], nil)
+		m.batches[i] = append(m.batches[i], scoredJob{ID: jobID, Score: score, Key: m.keys[i]})
+	}
+	m.offsets[i] += len(m.batches[i])
+	if len(m.batches[i]) < SCAN_BATCH_SIZE {
+		m.done[i] = true
+	}
+	return nil
+}
@@ -0,0 +1,260 @@
+package logic
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dcsunny/delayer/utils"
+	"github.com/gomodule/redigo/redis"
+)
+
+// 配置错误, 重试无法恢复
+type ConfigError struct {
+	Err error
+	// 错误分类, 为空时按原始错误判断, 无法判断时为 ERROR_CONFIG
+	Category ErrorCategory
+}
+
+// 错误信息
+func (e *ConfigError) Error() string {
+	return "configuration error: " + e.Err.Error()
+}
+
+// 是否为配置错误
+func IsConfigError(err error) bool {
+	_, ok := err.(*ConfigError)
+	return ok
+}
+
+const (
+	ROLE_TIMER = "timer"
+	ROLE_ADMIN = "admin"
+)
+
+const (
+	// 连接失败回调的最小间隔
+	DIAL_ERROR_INTERVAL = 10 * time.Second
+)
+
+// 创建连接池, onDialError 不为 nil 时连接失败回调
+func newPool(config utils.Config, password string, role string, onDialError func(err error)) *redis.Pool {
+	return &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			c, err := dial(config, password, role)
+			if err != nil && onDialError != nil {
+				onDialError(err)
+			}
+			return c, err
+		},
+		MaxIdle:         config.Redis.MaxIdle,
+		MaxActive:       config.Redis.MaxActive,
+		IdleTimeout:     time.Duration(config.Redis.IdleTimeout) * time.Second,
+		MaxConnLifetime: time.Duration(config.Redis.ConnMaxLifetime) * time.Second,
+	}
+}
+
+// 建立连接
+func dial(config utils.Config, password string, role string) (redis.Conn, error) {
+	c, err := redis.Dial("tcp", config.Redis.Host+":"+config.Redis.Port,
+		redis.DialConnectTimeout(time.Duration(config.Redis.DialTimeout)*time.Second),
+		redis.DialReadTimeout(time.Duration(config.Redis.ReadTimeout)*time.Second),
+		redis.DialWriteTimeout(time.Duration(config.Redis.WriteTimeout)*time.Second),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if password != "" {
+		if _, err := c.Do("AUTH", password); err != nil {
+			c.Close()
+			return nil, classifyError(err)
+		}
+	}
+	if err := selectDatabase(c, config); err != nil {
+		c.Close()
+		return nil, classifyError(err)
+	}
+	setName(c, config, role)
+	return c, nil
+}
+
+// 设置连接名称, 便于 CLIENT LIST 中识别, 服务器不支持时忽略
+func setName(c redis.Conn, config utils.Config, role string) {
+	if config.Redis.Compat || role == "" {
+		return
+	}
+	namespace := config.Delayer.Namespace
+	if namespace == "" {
+		namespace = DEFAULT_NAMESPACE
+	}
+	c.Do("CLIENT", "SETNAME", namespace+":"+role)
+}
+
+// 连接失败回调限流, 间隔内只回调一次
+type dialThrottle struct {
+	mutex sync.Mutex
+	last  time.Time
+}
+
+// 是否允许回调
+func (t *dialThrottle) allow() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	now := time.Now()
+	if now.Sub(t.last) < DIAL_ERROR_INTERVAL {
+		return false
+	}
+	t.last = now
+	return true
+}
+
+// 是否为服务器不支持的命令或子命令
+func unknownCommand(err error) bool {
+	e, ok := err.(redis.Error)
+	if !ok {
+		return false
+	}
+	msg := strings.ToLower(string(e))
+	return strings.HasPrefix(msg, "err unknown") || strings.Contains(msg, "syntax error")
+}
+
+// 校验连接池可用, 未配置密码时认证错误在首个命令返回, 同样视为配置错误
+func checkPool(pool *redis.Pool) error {
+	conn := pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("PING")
+	return classifyError(err)
+}
+
+// 区分配置错误与临时错误
+func classifyError(err error) error {
+	if _, ok := err.(*ConfigError); ok {
+		return err
+	}
+	if _, ok := err.(redis.Error); !ok {
+		return err
+	}
+	message := err.Error()
+	for _, prefix := range []string{"WRONGPASS", "NOAUTH", "ERR invalid password", "ERR AUTH", "ERR Client sent AUTH", "ERR DB index", "ERR invalid DB index", "ERR SELECT"} {
+		if strings.HasPrefix(message, prefix) {
+			return &ConfigError{Err: err}
+		}
+	}
+	return err
+}
+
+// 是否为主从切换后写入旧主节点的错误, 需重新建立连接
+func isFailoverError(err error) bool {
+	if _, ok := err.(redis.Error); !ok {
+		return false
+	}
+	message := err.Error()
+	return strings.HasPrefix(message, "READONLY") || strings.HasPrefix(message, "MASTERDOWN")
+}
+
+// 选择数据库, 集群模式与未显式配置的 0 号库不执行 SELECT
+func selectDatabase(c redis.Conn, config utils.Config) error {
+	database := config.Redis.Database
+	explicit := config.Redis.DatabaseSet
+	// 命名空间绑定的数据库优先
+	if config.Delayer.NamespaceDatabase != nil {
+		database = *config.Delayer.NamespaceDatabase
+		explicit = true
+	}
+	if config.Redis.Cluster || (database == 0 && !explicit) {
+		return nil
+	}
+	if _, err := c.Do("SELECT", database); err != nil {
+		return err
+	}
+	// 0 号库与兼容模式下不校验
+	if database == 0 || config.Redis.Compat {
+		return nil
+	}
+	// 校验当前数据库
+	info, err := redis.String(c.Do("CLIENT", "INFO"))
+	if err != nil {
+		// CLIENT INFO 自 Redis 6.2 起支持, 旧版本跳过校验
+		if unknownCommand(err) {
+			return nil
+		}
+		return err
+	}
+	for _, field := range strings.Fields(info) {
+		if !strings.HasPrefix(field, "db=") {
+			continue
+		}
+		if field != "db="+utils.IntToString(database) {
+			return &ConfigError{Err: fmt.Errorf("selected database mismatch, expected %d, got %s", database, strings.TrimPrefix(field, "db="))}
+		}
+		return nil
+	}
+	return errors.New("selected database cannot be verified")
+}
+
+// 获取密码, 配置了密码文件时优先读取文件
+func readPassword(config utils.Config) (string, error) {
+	fileName := config.Redis.PasswordFile
+	if fileName == "" {
+		return config.Redis.Password, nil
+	}
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return "", &ConfigError{Err: fmt.Errorf("redis password file read error: %s, %s", fileName, err.Error()), Category: ERROR_AUTH}
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// 记录连接池建立的连接, 停止超时时关闭全部连接以中断阻塞中的命令
+type connTracker struct {
+	mutex sync.Mutex
+	conns map[*trackedConn]bool
+}
+
+// 被记录的连接, 关闭时移除记录
+type trackedConn struct {
+	redis.Conn
+	tracker *connTracker
+}
+
+// 关闭连接
+func (c *trackedConn) Close() error {
+	c.tracker.mutex.Lock()
+	delete(c.tracker.conns, c)
+	c.tracker.mutex.Unlock()
+	return c.Conn.Close()
+}
+
+// 包装连接函数, 记录建立的连接
+func (t *connTracker) wrap(dial func() (redis.Conn, error)) func() (redis.Conn, error) {
+	return func() (redis.Conn, error) {
+		c, err := dial()
+		if err != nil {
+			return nil, err
+		}
+		tc := &trackedConn{Conn: c, tracker: t}
+		t.mutex.Lock()
+		if t.conns == nil {
+			t.conns = make(map[*trackedConn]bool)
+		}
+		t.conns[tc] = true
+		t.mutex.Unlock()
+		return tc, nil
+	}
+}
+
+// 关闭全部连接, 包括使用中的连接
+func (t *connTracker) closeAll() {
+	t.mutex.Lock()
+	conns := make([]*trackedConn, 0, len(t.conns))
+	for c := range t.conns {
+		conns = append(conns, c)
+	}
+	t.mutex.Unlock()
+	for _, c := range conns {
+		c.Close()
+	}
+}
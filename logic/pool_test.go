@@ -0,0 +1,462 @@
+package logic
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dcsunny/delayer/utils"
+)
+
+func TestReadPasswordFromFile(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "password")
+	if err := ioutil.WriteFile(fileName, []byte("secret\r\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s := newFakeRedis(t)
+	config := s.config()
+	config.Redis.Password = "inline"
+	config.Redis.PasswordFile = fileName
+	password, err := readPassword(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if password != "secret" {
+		t.Fatalf("expected password from file, got %q", password)
+	}
+	// 文件中的密码优先, 可完成认证
+	s.requireAuth("secret")
+	c, err := dial(config, password, ROLE_TIMER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+}
+
+func TestReadPasswordInline(t *testing.T) {
+	s := newFakeRedis(t)
+	config := s.config()
+	config.Redis.Password = "inline"
+	password, err := readPassword(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if password != "inline" {
+		t.Fatalf("expected inline password, got %q", password)
+	}
+}
+
+func TestReadPasswordUnreadableFile(t *testing.T) {
+	s := newFakeRedis(t)
+	config := s.config()
+	config.Redis.PasswordFile = filepath.Join(t.TempDir(), "missing")
+	if _, err := readPassword(config); err == nil {
+		t.Fatal("expected an error for a missing password file")
+	}
+	timer := &Timer{Config: config, Logger: &testLogger{}}
+	if err := timer.Init(); !IsConfigError(err) {
+		t.Fatalf("expected a config error from Init, got %v", err)
+	}
+}
+
+func TestSelectDatabaseSkipped(t *testing.T) {
+	s := newFakeRedis(t)
+	for _, configure := range []func(config *utils.Config){
+		// 未显式配置的 0 号库
+		func(config *utils.Config) {},
+		// 集群模式只有 0 号库
+		func(config *utils.Config) {
+			config.Redis.Cluster = true
+			config.Redis.Database = 3
+			config.Redis.DatabaseSet = true
+		},
+	} {
+		config := s.config()
+		configure(&config)
+		c, err := dial(config, "", ROLE_TIMER)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Close()
+	}
+	if n := s.count("SELECT"); n != 0 {
+		t.Fatalf("expected no SELECT, got %d", n)
+	}
+}
+
+func TestSelectDatabaseVerified(t *testing.T) {
+	s := newFakeRedis(t)
+	config := s.config()
+	config.Redis.Database = 2
+	config.Redis.DatabaseSet = true
+	c, err := dial(config, "", ROLE_TIMER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	if s.count("SELECT") != 1 || s.count("CLIENT") == 0 {
+		t.Fatalf("expected SELECT and CLIENT INFO, got SELECT x%d, CLIENT x%d", s.count("SELECT"), s.count("CLIENT"))
+	}
+	// 服务器不支持 CLIENT INFO 时跳过校验
+	s.update(func() {
+		s.noClientInfo = true
+	})
+	c, err = dial(config, "", ROLE_TIMER)
+	if err != nil {
+		t.Fatalf("expected verification to be skipped, got %v", err)
+	}
+	c.Close()
+}
+
+func TestSelectDatabaseRejected(t *testing.T) {
+	s := newFakeRedis(t)
+	// 模拟代理拒绝 SELECT
+	s.setHook(func(cmd string, args []string) error {
+		if cmd == "SELECT" {
+			return fakeError("ERR SELECT is not allowed")
+		}
+		return nil
+	})
+	config := s.config()
+	config.Redis.Database = 2
+	config.Redis.DatabaseSet = true
+	if _, err := dial(config, "", ROLE_TIMER); err == nil {
+		t.Fatal("expected dial to fail when SELECT fails")
+	}
+}
+
+// 首次写入ReadyQueue时返回主从切换错误
+func readOnlyOnce(s *fakeRedis) {
+	var failed int32
+	s.setHook(func(cmd string, args []string) error {
+		if cmd == "LPUSH" && atomic.CompareAndSwapInt32(&failed, 0, 1) {
+			return fakeError("READONLY You can't write against a read only replica.")
+		}
+		return nil
+	})
+}
+
+func TestRedialOnReadOnly(t *testing.T) {
+	s := newFakeRedis(t)
+	now := time.Now().Unix()
+	s.addJob("a", "mail", now-1)
+	readOnlyOnce(s)
+	timer := newTestTimer(t, s, func(config *utils.Config) {
+		config.Redis.Redial = true
+	})
+	old := timer.pool()
+	timer.tick()
+	if timer.pool() == old {
+		t.Fatal("expected the pool to be rebuilt after READONLY")
+	}
+	if !timer.Logger.(*testLogger).contains("redialing") {
+		t.Fatal("expected a redial warning")
+	}
+	assertPending(t, s, "a")
+	// 重建后的连接池可继续使用
+	conn := timer.pool().Get()
+	defer conn.Close()
+	if _, err := conn.Do("PING"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNoRedialWhenDisabled(t *testing.T) {
+	s := newFakeRedis(t)
+	s.addJob("a", "mail", time.Now().Unix()-1)
+	readOnlyOnce(s)
+	timer := newTestTimer(t, s, nil)
+	old := timer.pool()
+	timer.tick()
+	if timer.pool() != old {
+		t.Fatal("pool should not be rebuilt when redial is disabled")
+	}
+}
+
+func TestSetPoolLimitsValidates(t *testing.T) {
+	s := newFakeRedis(t)
+	timer := newTestTimer(t, s, nil)
+	for _, limits := range [][2]int{{-1, 10}, {10, -1}, {20, 10}} {
+		if err := timer.SetPoolLimits(limits[0], limits[1]); err == nil {
+			t.Errorf("expected an error for max_idle %d, max_active %d", limits[0], limits[1])
+		}
+	}
+	// max_active 为 0 时不限制, 不校验 max_idle
+	if err := timer.SetPoolLimits(20, 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSetPoolLimitsWhenStopped(t *testing.T) {
+	s := newFakeRedis(t)
+	timer := newTestTimer(t, s, nil)
+	if err := timer.SetPoolLimits(1, 1); err != nil {
+		t.Fatal(err)
+	}
+	pool := timer.pool()
+	if pool.MaxIdle != 1 || pool.MaxActive != 1 {
+		t.Fatalf("expected limits 1/1, got %d/%d", pool.MaxIdle, pool.MaxActive)
+	}
+	// 新的上限对后续获取的连接生效
+	first := pool.Get()
+	defer first.Close()
+	if _, err := first.Do("PING"); err != nil {
+		t.Fatal(err)
+	}
+	second := pool.Get()
+	defer second.Close()
+	if second.Err() == nil {
+		t.Fatal("expected the pool to be exhausted beyond max_active")
+	}
+}
+
+func TestSetPoolLimitsWhileRunning(t *testing.T) {
+	s := newFakeRedis(t)
+	timer := newTestTimer(t, s, func(config *utils.Config) {
+		config.Delayer.TimerInterval = 20
+	})
+	timer.Start()
+	if err := timer.SetPoolLimits(2, 4); err != nil {
+		t.Fatal(err)
+	}
+	// 在执行间隙生效
+	waitFor(t, time.Second, "pool limits were not applied", func() bool {
+		return timer.pool().MaxActive == 4
+	})
+	if timer.pool().MaxIdle != 2 {
+		t.Fatalf("expected max_idle 2, got %d", timer.pool().MaxIdle)
+	}
+}
+
+func TestOnDialErrorThrottled(t *testing.T) {
+	s := newFakeRedis(t)
+	timer := newTestTimer(t, s, nil)
+	var calls int32
+	timer.OnDialError = func(err error) {
+		atomic.AddInt32(&calls, 1)
+	}
+	// 服务器关闭后重新建立连接失败
+	s.close()
+	for i := 0; i < 3; i++ {
+		timer.tick()
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("expected a single throttled callback, got %d", n)
+	}
+}
+
+func TestInitRejectsInvalidConfig(t *testing.T) {
+	s := newFakeRedis(t)
+	config := s.config()
+	config.Delayer.TimerInterval = 0
+	timer := &Timer{Config: config, Logger: &testLogger{}}
+	err := timer.Init()
+	if !IsConfigError(err) {
+		t.Fatalf("expected a config error, got %v", err)
+	}
+}
+
+func TestDialUnroutableTimesOut(t *testing.T) {
+	config := newFakeRedis(t).config()
+	// 保留的不可路由地址, 连接请求不会得到响应
+	config.Redis.Host = "10.255.255.1"
+	config.Redis.Port = "6379"
+	config.Redis.DialTimeout = 1
+	start := time.Now()
+	c, err := dial(config, "", "")
+	if err == nil {
+		// 部分沙箱或代理环境会直接接受任意地址的连接
+		c.Close()
+		t.Skip("connections to unroutable addresses are intercepted in this environment")
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Fatalf("expected the dial to give up within the timeout, took %s", elapsed)
+	}
+}
+
+func TestReadTimeout(t *testing.T) {
+	s := newFakeRedis(t)
+	s.setHook(func(cmd string, args []string) error {
+		if cmd == "PING" {
+			return errHang
+		}
+		return nil
+	})
+	config := s.config()
+	config.Redis.ReadTimeout = 1
+	c, err := dial(config, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	start := time.Now()
+	_, err = c.Do("PING")
+	if err == nil {
+		t.Fatal("expected a read timeout")
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Fatalf("expected the read to time out within the timeout, took %s", elapsed)
+	}
+	if Categorize(err) != ERROR_TIMEOUT {
+		t.Fatalf("expected a timeout error, got %s: %v", Categorize(err), err)
+	}
+}
+
+func TestInitFailsFastOnConfigErrors(t *testing.T) {
+	s := newFakeRedis(t)
+	s.requireAuth("secret")
+	for _, c := range []struct {
+		name      string
+		configure func(config *utils.Config)
+		category  ErrorCategory
+	}{
+		{"wrong password", func(config *utils.Config) {
+			config.Redis.Password = "wrong"
+		}, ERROR_AUTH},
+		{"missing password", func(config *utils.Config) {}, ERROR_AUTH},
+		{"invalid database", func(config *utils.Config) {
+			config.Redis.Password = "secret"
+			config.Redis.Database = 99
+			config.Redis.DatabaseSet = true
+		}, ERROR_CONFIG},
+	} {
+		config := s.config()
+		c.configure(&config)
+		timer := &Timer{Config: config, Logger: &testLogger{}}
+		start := time.Now()
+		err := timer.Init()
+		if !IsConfigError(err) {
+			t.Fatalf("%s: expected a config error, got %v", c.name, err)
+		}
+		if category := Categorize(err); category != c.category {
+			t.Errorf("%s: expected category %s, got %s", c.name, c.category, category)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("%s: expected Init to fail fast, took %s", c.name, elapsed)
+		}
+		timer.Close()
+	}
+}
+
+func TestInitRetriesTransientErrors(t *testing.T) {
+	s := newFakeRedis(t)
+	s.addJob("a", "mail", time.Now().Unix()-1)
+	// 首次校验时连接断开
+	var dropped int32
+	s.setHook(func(cmd string, args []string) error {
+		if cmd == "PING" && atomic.CompareAndSwapInt32(&dropped, 0, 1) {
+			return errDrop
+		}
+		return nil
+	})
+	logger := &testLogger{}
+	timer := &Timer{Config: s.config(), Logger: logger}
+	if err := timer.Init(); err != nil {
+		t.Fatalf("expected a transient error not to fail Init, got %v", err)
+	}
+	defer timer.Close()
+	if atomic.LoadInt32(&dropped) != 1 {
+		t.Fatal("expected the startup check to hit the dropped connection")
+	}
+	if !logger.contains("func Init") {
+		t.Fatal("expected the transient error to be logged")
+	}
+	// 后续执行时重新建立连接
+	timer.tick()
+	assertQueue(t, s, "mail", "a")
+}
+
+func TestInitRejectsWrongKeyType(t *testing.T) {
+	s := newFakeRedis(t)
+	// 其他程序误写入字符串
+	s.do("SET", KEY_JOB_POOL, "oops")
+	timer := &Timer{Config: s.config(), Logger: &testLogger{}}
+	err := timer.Init()
+	defer timer.Close()
+	if !IsConfigError(err) {
+		t.Fatalf("expected a config error, got %v", err)
+	}
+	if message := err.Error(); !strings.Contains(message, KEY_JOB_POOL) || !strings.Contains(message, "type string") {
+		t.Fatalf("expected the key and its type in the error, got %q", message)
+	}
+	// 恢复为有序集合后可正常启动
+	s.do("DEL", KEY_JOB_POOL)
+	s.addJob("a", "mail", time.Now().Unix()-1)
+	timer = newTestTimer(t, s, nil)
+	timer.tick()
+	assertQueue(t, s, "mail", "a")
+}
+
+func TestSetNameOnDial(t *testing.T) {
+	s := newFakeRedis(t)
+	config := s.config()
+	for _, role := range []string{ROLE_TIMER, ""} {
+		c, err := dial(config, "", role)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Close()
+	}
+	config.Delayer.Namespace = "app"
+	c, err := dial(config, "", ROLE_ADMIN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	// 未指定角色时不设置名称
+	if names := s.clientNames(); !reflect.DeepEqual(names, []string{"delayer:timer", "app:admin"}) {
+		t.Fatalf("unexpected connection names %v", names)
+	}
+	// 服务器禁止 SETNAME 时忽略
+	s.setHook(func(cmd string, args []string) error {
+		if cmd == "CLIENT" {
+			return fakeError("ERR unknown command 'CLIENT'")
+		}
+		return nil
+	})
+	c, err = dial(config, "", ROLE_TIMER)
+	if err != nil {
+		t.Fatalf("expected SETNAME errors to be ignored, got %v", err)
+	}
+	defer c.Close()
+	if _, err := c.Do("PING"); err != nil {
+		t.Fatal(err)
+	}
+	if n := s.count("CLIENT"); n != 3 {
+		t.Fatalf("expected SETNAME on each named dial, got %d", n)
+	}
+}
+
+func TestSelectDatabaseExplicitZero(t *testing.T) {
+	s := newFakeRedis(t)
+	zero := 0
+	for _, configure := range []func(config *utils.Config){
+		func(config *utils.Config) {
+			config.Redis.DatabaseSet = true
+		},
+		// 命名空间绑定 0 号库
+		func(config *utils.Config) {
+			config.Redis.Database = 3
+			config.Delayer.NamespaceDatabase = &zero
+		},
+	} {
+		config := s.config()
+		configure(&config)
+		c, err := dial(config, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Close()
+	}
+	// 显式配置的 0 号库执行 SELECT 0, 无需校验
+	if n := s.count("SELECT"); n != 2 {
+		t.Fatalf("expected SELECT 0 for each explicit db 0, got %d", n)
+	}
+	if n := s.count("CLIENT"); n != 0 {
+		t.Fatalf("expected no CLIENT INFO for db 0, got %d", n)
+	}
+}
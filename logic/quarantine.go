@@ -0,0 +1,54 @@
+package logic
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dcsunny/delayer/utils"
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	// 就绪时间超过当前时间该时长视为异常, 如误用毫秒时间戳
+	MAX_SCORE_AHEAD = 100 * 365 * 24 * time.Hour
+)
+
+// 将就绪时间为负数或过大的任务移至隔离集合, 保留原就绪时间, 由人工处理
+func (p *Timer) quarantine() {
+	conn := p.pool().Get()
+	defer conn.Close()
+	limit := time.Now().Add(MAX_SCORE_AHEAD).Unix()
+	var jobIDs []string
+	var scores []string
+	for _, bounds := range [][]interface{}{{"-inf", "(0"}, {"(" + fmt.Sprint(limit), "+inf"}} {
+		values, err := redis.Values(conn.Do("ZRANGEBYSCORE", p.keys.JobPool, bounds[0], bounds[1], "WITHSCORES", "LIMIT", 0, SCAN_BATCH_SIZE))
+		if err != nil {
+			p.fail(err, "quarantine", "")
+			return
+		}
+		for i := 0; i+1 < len(values); i += 2 {
+			jobID, _ := redis.String(values[i], nil)
+			score, _ := redis.String(values[i+1], nil)
+			jobIDs = append(jobIDs, jobID)
+			scores = append(scores, score)
+		}
+	}
+	if len(jobIDs) == 0 {
+		return
+	}
+	zadd := redis.Args{}.Add(p.keys.Quarantine)
+	for i, jobID := range jobIDs {
+		zadd = zadd.Add(scores[i], jobID)
+	}
+	jobIDsStr := strings.Join(jobIDs, ",")
+	conn.Send("MULTI")
+	conn.Send("ZREM", redis.Args{}.Add(p.keys.JobPool).AddFlat(jobIDs)...)
+	conn.Send("ZADD", zadd...)
+	if _, err := conn.Do("EXEC"); err != nil {
+		p.fail(err, "quarantine", jobIDsStr)
+		return
+	}
+	logger := utils.WithFields(p.Logger, utils.F("ids", jobIDs))
+	logger.Warn(fmt.Sprintf("Job quarantined, invalid score, IDs: [%s]", jobIDsStr))
+}
@@ -0,0 +1,43 @@
+package logic
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestInvalidScoresQuarantined(t *testing.T) {
+	s := newFakeRedis(t)
+	now := time.Now().Unix()
+	s.addJob("a", "mail", now-1)
+	s.addJob("later", "mail", now+3600)
+	s.addJob("negative", "mail", -5)
+	far := time.Now().Add(2 * MAX_SCORE_AHEAD).Unix()
+	s.addJob("far", "mail", far)
+	timer := newTestTimer(t, s, nil)
+	timer.tick()
+	for jobID, expected := range map[string]int64{
+		"negative": -5,
+		"far":      far,
+	} {
+		score, ok := s.score(KEY_QUARANTINE, jobID)
+		if !ok {
+			t.Fatalf("job %s is not quarantined", jobID)
+		}
+		// 保留原分数以便排查
+		if int64(score) != expected {
+			t.Errorf("job %s: expected score %d, got %s", jobID, expected, strconv.FormatFloat(score, 'f', -1, 64))
+		}
+		if _, ok := s.score(KEY_JOB_POOL, jobID); ok {
+			t.Errorf("quarantined job %s is still in the pool", jobID)
+		}
+	}
+	assertQueue(t, s, "mail", "a")
+	assertPending(t, s, "later")
+	if _, ok := s.score(KEY_QUARANTINE, "later"); ok {
+		t.Fatal("valid job was quarantined")
+	}
+	if !timer.Logger.(*testLogger).contains("Job quarantined") {
+		t.Fatal("expected a quarantine warning")
+	}
+}
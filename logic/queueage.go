@@ -0,0 +1,119 @@
+package logic
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/dcsunny/delayer/utils"
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	// 进入ReadyQueue的时间字段, 开启 queue_age_threshold 时由定时器移动任务时写入
+	FIELD_READY_AT = "ready_at"
+	// 定时器检查ReadyQueue等待时间的间隔
+	QUEUE_AGE_CHECK_INTERVAL = time.Minute
+)
+
+// 记录进入ReadyQueue的时间, 仅写入仍存在的 bucket, 避免重建已被消费方删除的任务
+var readyAtScript = redis.NewScript(-1, `
+for _, key in ipairs(KEYS) do
+	if redis.call('EXISTS', key) == 1 then
+		redis.call('HSET', key, ARGV[1], ARGV[2])
+	end
+end
+return #KEYS
+`)
+
+// 是否记录 ready_at, 仅开启 queue_age_threshold 时记录, 兼容模式下无法使用 EVAL
+func (p *Timer) stampsReadyAt() bool {
+	return p.Config.Delayer.QueueAgeThreshold > 0 && !p.Config.Redis.Compat
+}
+
+// 发送记录 ready_at 的脚本, 时间为实际移动时间而非计划就绪时间, 使消费滞后不含调度延迟
+func (p *Timer) sendReadyAt(conn redis.Conn, jobIDs []string) error {
+	args := redis.Args{}.Add(len(jobIDs))
+	for _, jobID := range jobIDs {
+		args = args.Add(p.keys.JobBucket + jobID)
+	}
+	args = args.Add(FIELD_READY_AT, time.Now().Unix())
+	return readyAtScript.Send(conn, args...)
+}
+
+// 各ReadyQueue中最早任务的等待时间, 按 bucket 的 ready_at 计算, 未记录 ready_at 的Topic不返回
+func (p *Admin) QueueAges() (map[string]time.Duration, error) {
+	stats, err := p.scanTopics()
+	if err != nil {
+		return nil, err
+	}
+	conn := p.Pool.Get()
+	defer conn.Close()
+	// 客户端从右侧取出, 先进先出时最早的任务在右侧
+	index := -1
+	if p.ReadyOrder == utils.READY_ORDER_LIFO {
+		index = 0
+	}
+	now := time.Now()
+	ages := make(map[string]time.Duration)
+	for topic, length := range stats {
+		if length == 0 {
+			continue
+		}
+		jobID, err := redis.String(conn.Do("LINDEX", p.keys().ReadyQueue+topic, index))
+		if err == redis.ErrNil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		value, err := redis.String(conn.Do("HGET", p.keys().JobBucket+jobID, FIELD_READY_AT))
+		if err == redis.ErrNil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		readyAt, err := utils.StringToInt64(value)
+		if err != nil {
+			continue
+		}
+		ages[topic] = now.Sub(time.Unix(readyAt, 0))
+	}
+	return ages, nil
+}
+
+// 检查ReadyQueue等待时间, 超过 queue_age_threshold 时告警, 用于区分消费滞后与调度延迟
+func (p *Timer) checkQueueAges() {
+	threshold := time.Duration(p.Config.Delayer.QueueAgeThreshold) * time.Second
+	if threshold <= 0 || time.Since(p.queueAgeTime) < QUEUE_AGE_CHECK_INTERVAL {
+		return
+	}
+	p.queueAgeTime = time.Now()
+	admin := &Admin{
+		Pool:       p.pool(),
+		Namespace:  p.Config.Delayer.Namespace,
+		ReadyOrder: p.Config.Delayer.ReadyOrder,
+	}
+	ages, err := admin.QueueAges()
+	if err != nil {
+		p.handleError(err, "checkQueueAges", "")
+		return
+	}
+	topics := make([]string, 0, len(ages))
+	for topic := range ages {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	for _, topic := range topics {
+		age := ages[topic]
+		if age <= threshold {
+			continue
+		}
+		logger := utils.WithFields(p.Logger, utils.F("topic", topic), utils.F("age", age))
+		logger.Warn(fmt.Sprintf("Ready queue lagging, Topic: %s, oldest: %s", topic, age))
+		if p.OnQueueLag != nil {
+			p.OnQueueLag(topic, age)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package logic
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/dcsunny/delayer/utils"
+)
+
+func TestReadyAtStamped(t *testing.T) {
+	for _, cross := range []bool{false, true} {
+		cross := cross
+		t.Run("cross="+strconv.FormatBool(cross), func(t *testing.T) {
+			s := newFakeRedis(t)
+			// 计划在 100 秒前就绪, ready_at 记录实际移动时间
+			s.addJob("a", "mail", time.Now().Unix()-100)
+			timer := newTestTimer(t, s, func(config *utils.Config) {
+				config.Redis.CrossSlot = cross
+				config.Delayer.QueueAgeThreshold = 60
+			})
+			start := time.Now().Unix()
+			timer.tick()
+			assertQueue(t, s, "mail", "a")
+			readyAt, err := utils.StringToInt64(s.field("a", FIELD_READY_AT))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if readyAt < start || readyAt > time.Now().Unix() {
+				t.Fatalf("expected ready_at to be the move time, got %d", readyAt)
+			}
+		})
+	}
+}
+
+func TestReadyAtNotStampedWithoutThreshold(t *testing.T) {
+	s := newFakeRedis(t)
+	s.addJob("a", "mail", time.Now().Unix()-1)
+	timer := newTestTimer(t, s, nil)
+	timer.tick()
+	assertQueue(t, s, "mail", "a")
+	if v := s.field("a", FIELD_READY_AT); v != "" {
+		t.Fatalf("expected no ready_at, got %q", v)
+	}
+	if n := s.count("EVAL"); n != 0 {
+		t.Fatalf("expected no scripts, got %d", n)
+	}
+}
+
+func TestReadyAtSkipsDeletedBucket(t *testing.T) {
+	for _, cross := range []bool{false, true} {
+		cross := cross
+		t.Run("cross="+strconv.FormatBool(cross), func(t *testing.T) {
+			s := newFakeRedis(t)
+			s.addJob("a", "mail", time.Now().Unix()-1)
+			// 消费方在记录 ready_at 前已取出并删除任务
+			s.setHook(func(cmd string, args []string) error {
+				if cmd == "EVAL" && args[len(args)-2] == FIELD_READY_AT {
+					s.do("DEL", PREFIX_JOB_BUCKET+"a")
+				}
+				return nil
+			})
+			timer := newTestTimer(t, s, func(config *utils.Config) {
+				config.Redis.CrossSlot = cross
+				config.Delayer.QueueAgeThreshold = 60
+			})
+			timer.tick()
+			assertQueue(t, s, "mail", "a")
+			if n := s.do("EXISTS", PREFIX_JOB_BUCKET+"a"); n != int64(0) {
+				t.Fatal("deleted bucket was recreated")
+			}
+		})
+	}
+}
+
+func TestQueueLagReported(t *testing.T) {
+	s := newFakeRedis(t)
+	now := time.Now().Unix()
+	// 已在ReadyQueue中等待 10 分钟的任务, 先进先出时最早的任务在右侧
+	s.do("LPUSH", PREFIX_READY_QUEUE+"mail", "old")
+	s.do("HSET", PREFIX_JOB_BUCKET+"old", FIELD_TOPIC, "mail", FIELD_READY_AT, strconv.FormatInt(now-600, 10))
+	s.do("LPUSH", PREFIX_READY_QUEUE+"mail", "new")
+	s.do("HSET", PREFIX_JOB_BUCKET+"new", FIELD_TOPIC, "mail", FIELD_READY_AT, strconv.FormatInt(now, 10))
+	// 未记录 ready_at 的任务无法计算, 不按计划就绪时间估算
+	s.do("LPUSH", PREFIX_READY_QUEUE+"sms", "legacy")
+	s.do("HSET", PREFIX_JOB_BUCKET+"legacy", FIELD_TOPIC, "sms", FIELD_BASE, strconv.FormatInt(now-400, 10), FIELD_OFFSET, "100")
+	s.do("LPUSH", PREFIX_READY_QUEUE+"push", "fresh")
+	s.do("HSET", PREFIX_JOB_BUCKET+"fresh", FIELD_TOPIC, "push", FIELD_READY_AT, strconv.FormatInt(now-5, 10))
+	timer := newTestTimer(t, s, func(config *utils.Config) {
+		config.Delayer.QueueAgeThreshold = 60
+	})
+	lags := make(map[string]time.Duration)
+	timer.OnQueueLag = func(topic string, age time.Duration) {
+		lags[topic] = age
+	}
+	timer.tick()
+	if len(lags) != 1 {
+		t.Fatalf("expected a lag report for mail only, got %v", lags)
+	}
+	if lags["mail"] < 600*time.Second {
+		t.Errorf("mail: expected a lag of at least 600s, got %s", lags["mail"])
+	}
+	if !timer.Logger.(*testLogger).contains("Ready queue lagging") {
+		t.Fatal("expected a lag warning")
+	}
+	// 检查间隔内不重复检查
+	lags = make(map[string]time.Duration)
+	timer.tick()
+	if len(lags) != 0 {
+		t.Fatalf("expected no reports within the check interval, got %v", lags)
+	}
+}
@@ -0,0 +1,122 @@
+package logic
+
+import (
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// 将移动失败的任务暂存至RetryPool, 避免每次扫描都重复失败
+func (p *Timer) parkRetry(jobIDs []string) {
+	delay := p.Config.Delayer.RetryDelay
+	if delay <= 0 {
+		return
+	}
+	p.park(jobIDs, time.Duration(delay)*time.Second)
+}
+
+// 将任务暂存至RetryPool, 到期后放回JobPool
+func (p *Timer) park(jobIDs []string, delay time.Duration) {
+	if len(jobIDs) == 0 {
+		return
+	}
+	conn := p.pool().Get()
+	defer conn.Close()
+	// 仅暂存仍在JobPool中的任务, 已被移动的不再重复处理
+	pending, err := p.pendingJobs(conn, jobIDs)
+	if err != nil {
+		p.fail(err, "parkRetry", strings.Join(jobIDs, ","))
+		return
+	}
+	if len(pending) == 0 {
+		return
+	}
+	jobIDs = pending
+	// 就绪时间以秒为单位, 至少暂存1秒
+	seconds := int64((delay + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	score := p.now(conn) + seconds
+	zrem := redis.Args{}.Add(p.keys.JobPool).AddFlat(jobIDs)
+	zadd := redis.Args{}.Add(p.keys.RetryPool)
+	for _, jobID := range jobIDs {
+		zadd = zadd.Add(score, jobID)
+	}
+	conn.Send("MULTI")
+	conn.Send("ZREM", zrem...)
+	conn.Send("ZADD", zadd...)
+	if _, err := conn.Do("EXEC"); err != nil {
+		p.fail(err, "parkRetry", strings.Join(jobIDs, ","))
+	}
+}
+
+// 将RetryPool中到期的任务放回JobPool, 由本次执行一并移动
+func (p *Timer) drainRetry() {
+	conn := p.pool().Get()
+	defer conn.Close()
+	now := p.now(conn)
+	jobIDs, err := redis.Strings(conn.Do("ZRANGEBYSCORE", p.keys.RetryPool, "0", now, "LIMIT", 0, MAX_BATCH_SIZE))
+	if err != nil {
+		p.fail(err, "drainRetry", "")
+		return
+	}
+	if len(jobIDs) == 0 {
+		return
+	}
+	zrem := redis.Args{}.Add(p.keys.RetryPool).AddFlat(jobIDs)
+	zadd := redis.Args{}.Add(p.keys.JobPool)
+	for _, jobID := range jobIDs {
+		zadd = zadd.Add(now, jobID)
+	}
+	conn.Send("MULTI")
+	conn.Send("ZREM", zrem...)
+	conn.Send("ZADD", zadd...)
+	if _, err := conn.Do("EXEC"); err != nil {
+		p.fail(err, "drainRetry", strings.Join(jobIDs, ","))
+	}
+}
+
+// 获取仍在JobPool中的任务
+func (p *Timer) pendingJobs(conn redis.Conn, jobIDs []string) ([]string, error) {
+	for _, jobID := range jobIDs {
+		conn.Send("ZSCORE", p.keys.JobPool, jobID)
+	}
+	if err := conn.Flush(); err != nil {
+		return nil, err
+	}
+	var pending []string
+	for _, jobID := range jobIDs {
+		if _, err := scoreInt64(conn.Receive()); err == nil {
+			pending = append(pending, jobID)
+		}
+	}
+	return pending, nil
+}
+
+// 本次执行内重试移动, 仅重试仍在JobPool中的任务, 超过截止时间不再重试
+func (p *Timer) retryMove(jobIDs []string, topic string, scores map[string]int64, deadline time.Time) bool {
+	wait := time.Duration(p.Config.Delayer.MoveRetryWait) * time.Millisecond
+	for i := 0; i < p.Config.Delayer.MoveRetries; i++ {
+		if time.Now().Add(wait).After(deadline) {
+			return false
+		}
+		time.Sleep(wait)
+		conn := p.pool().Get()
+		pending, err := p.pendingJobs(conn, jobIDs)
+		conn.Close()
+		if err != nil {
+			p.fail(err, "retryMove", strings.Join(jobIDs, ","))
+			continue
+		}
+		if len(pending) == 0 {
+			return true
+		}
+		jobIDs = pending
+		if p.moveJobToReadyQueue(jobIDs, topic, scores) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package logic
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dcsunny/delayer/utils"
+)
+
+func TestFailedMoveParkedInRetryPool(t *testing.T) {
+	s := newFakeRedis(t)
+	now := time.Now().Unix()
+	s.addJob("a", "mail", now-1)
+	var attempts int32
+	failQueue(s, "mail", &attempts)
+	timer := newTestTimer(t, s, func(config *utils.Config) {
+		config.Delayer.TimerInterval = 10
+		config.Delayer.RetryDelay = 1
+	})
+	timer.tick()
+	score, ok := s.score(KEY_RETRY_POOL, "a")
+	if !ok {
+		t.Fatal("failed job is not in the retry pool")
+	}
+	if int64(score) <= now {
+		t.Fatalf("expected a retry score in the future, got %d", int64(score))
+	}
+	if _, ok := s.score(KEY_JOB_POOL, "a"); ok {
+		t.Fatal("failed job is still in the pool")
+	}
+	// 暂存期间不参与扫描
+	timer.tick()
+	if n := atomic.LoadInt32(&attempts); n != 1 {
+		t.Fatalf("expected 1 attempt while parked, got %d", n)
+	}
+	// 恢复后到期, 放回JobPool并移动
+	s.setHook(nil)
+	s.do("ZADD", KEY_RETRY_POOL, strconv.FormatInt(now-1, 10), "a")
+	time.Sleep(20 * time.Millisecond)
+	timer.tick()
+	assertQueue(t, s, "mail", "a")
+	if _, ok := s.score(KEY_RETRY_POOL, "a"); ok {
+		t.Fatal("moved job is still in the retry pool")
+	}
+}
+
+func TestMoveRetriedWithinTick(t *testing.T) {
+	s := newFakeRedis(t)
+	s.addJob("a", "mail", time.Now().Unix()-1)
+	var attempts int32
+	s.setHook(func(cmd string, args []string) error {
+		if cmd == "LPUSH" && atomic.AddInt32(&attempts, 1) == 1 {
+			return errDrop
+		}
+		return nil
+	})
+	timer := newTestTimer(t, s, func(config *utils.Config) {
+		config.Delayer.MoveRetries = 2
+		config.Delayer.MoveRetryWait = 5
+		config.Delayer.RetryDelay = 1
+	})
+	timer.tick()
+	// 第二次尝试成功, 无需等待下次执行
+	assertQueue(t, s, "mail", "a")
+	if n := atomic.LoadInt32(&attempts); n != 2 {
+		t.Fatalf("expected 2 attempts, got %d", n)
+	}
+	if _, ok := s.score(KEY_RETRY_POOL, "a"); ok {
+		t.Fatal("retried job was parked")
+	}
+}
+
+func TestMoveRetriesBoundedByInterval(t *testing.T) {
+	s := newFakeRedis(t)
+	s.addJob("a", "mail", time.Now().Unix()-1)
+	var attempts int32
+	failQueue(s, "mail", &attempts)
+	timer := newTestTimer(t, s, func(config *utils.Config) {
+		config.Delayer.TimerInterval = 50
+		config.Delayer.MoveRetries = 100
+		config.Delayer.MoveRetryWait = 20
+	})
+	start := time.Now()
+	timer.tick()
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Fatalf("expected retries to stop near the interval, took %s", elapsed)
+	}
+	assertPending(t, s, "a")
+}
@@ -0,0 +1,34 @@
+package logic
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// 读取有序集合分数, 兼容外部写入的小数分数, 向下取整到秒
+func scoreInt64(reply interface{}, err error) (int64, error) {
+	score, err := redis.Float64(reply, err)
+	if err != nil {
+		return 0, err
+	}
+	return int64(math.Floor(score)), nil
+}
+
+// 读取 WITHSCORES 回复, 分数处理同 scoreInt64
+func scoreMap(reply interface{}, err error) (map[string]int64, error) {
+	values, err := redis.StringMap(reply, err)
+	if err != nil {
+		return nil, err
+	}
+	scores := make(map[string]int64, len(values))
+	for member, value := range values {
+		score, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, err
+		}
+		scores[member] = int64(math.Floor(score))
+	}
+	return scores, nil
+}
@@ -0,0 +1,61 @@
+package logic
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	// 自检使用的保留Topic
+	SELF_TEST_TOPIC = "_selftest"
+	// 自检任务的延迟
+	SELF_TEST_DELAY = time.Second
+	// 自检轮询间隔
+	SELF_TEST_POLL = 100 * time.Millisecond
+)
+
+// 自检, 推送一个短延迟任务, 等待定时器移动后取出并确认, 返回从推送到就绪的耗时
+func (p *Admin) SelfTest(ctx context.Context) (time.Duration, error) {
+	conn := p.Pool.Get()
+	defer conn.Close()
+	keys := p.keys()
+	jobID := fmt.Sprintf("%s:%d", SELF_TEST_TOPIC, time.Now().UnixNano())
+	groupField := p.groupField()
+	start := time.Now()
+	// 清理, 无论成功与否
+	defer func() {
+		conn.Send("MULTI")
+		conn.Send("ZREM", keys.JobPool, jobID)
+		conn.Send("LREM", keys.ReadyQueue+SELF_TEST_TOPIC, 0, jobID)
+		conn.Send("DEL", keys.JobBucket+jobID)
+		conn.Do("EXEC")
+	}()
+	// 推送
+	conn.Send("MULTI")
+	conn.Send("HMSET", keys.JobBucket+jobID, FIELD_TOPIC, SELF_TEST_TOPIC, groupField, SELF_TEST_TOPIC, FIELD_VERSION, BUCKET_VERSION, "body", "selftest")
+	conn.Send("ZADD", keys.JobPool, start.Add(SELF_TEST_DELAY).Unix(), jobID)
+	if _, err := conn.Do("EXEC"); err != nil {
+		return 0, err
+	}
+	// 等待移动, 取出即确认
+	for {
+		n, err := redis.Int64(conn.Do("LREM", keys.ReadyQueue+SELF_TEST_TOPIC, 0, jobID))
+		if err != nil {
+			return 0, err
+		}
+		if n > 0 {
+			if _, err := conn.Do("DEL", keys.JobBucket+jobID); err != nil {
+				return 0, err
+			}
+			return time.Since(start), nil
+		}
+		select {
+		case <-ctx.Done():
+			return 0, fmt.Errorf("self test job %s not ready after %s: %w", jobID, time.Since(start), ctx.Err())
+		case <-time.After(SELF_TEST_POLL):
+		}
+	}
+}
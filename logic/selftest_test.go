@@ -0,0 +1,49 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dcsunny/delayer/utils"
+)
+
+// 自检结束后不应留下数据
+func assertSelfTestCleaned(t *testing.T, s *fakeRedis) {
+	t.Helper()
+	if keys, _ := s.do("KEYS", "*").([]string); len(keys) != 0 {
+		t.Fatalf("expected the self test to clean up, found %v", keys)
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	s := newFakeRedis(t)
+	timer := newTestTimer(t, s, func(config *utils.Config) {
+		config.Delayer.TimerInterval = 50
+	})
+	timer.Start()
+	defer timer.Stop()
+	admin := newTestAdmin(t, s)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	elapsed, err := admin.SelfTest(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed <= 0 || elapsed > SELF_TEST_DELAY+time.Second {
+		t.Fatalf("unexpected round trip time %s", elapsed)
+	}
+	assertSelfTestCleaned(t, s)
+}
+
+func TestSelfTestWithoutTimer(t *testing.T) {
+	s := newFakeRedis(t)
+	admin := newTestAdmin(t, s)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, err := admin.SelfTest(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deadline error without a running timer, got %v", err)
+	}
+	assertSelfTestCleaned(t, s)
+}
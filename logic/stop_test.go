@@ -0,0 +1,158 @@
+package logic
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dcsunny/delayer/utils"
+)
+
+// 获取到期任务的次数, 即执行次数
+func scans(s *fakeRedis) int {
+	return s.count("ZRANGEBYSCORE") + s.count("EVAL")
+}
+
+func TestStopDuringTicks(t *testing.T) {
+	s := newFakeRedis(t)
+	timer := newTestTimer(t, s, func(config *utils.Config) {
+		config.Delayer.TimerInterval = 1
+	})
+	s.setHook(func(cmd string, args []string) error {
+		if cmd == "ZRANGEBYSCORE" || cmd == "EVAL" {
+			time.Sleep(time.Millisecond)
+		}
+		return nil
+	})
+	for i := 0; i < 20; i++ {
+		s.addJob("job", "mail", time.Now().Unix()-1)
+		var wg sync.WaitGroup
+		for j := 0; j < 4; j++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				timer.Start()
+			}()
+			go func() {
+				defer wg.Done()
+				time.Sleep(time.Millisecond)
+				timer.Stop()
+			}()
+		}
+		wg.Wait()
+		timer.Stop()
+		// 停止返回后不再开始新的执行
+		before := scans(s)
+		time.Sleep(10 * time.Millisecond)
+		if after := scans(s); after != before {
+			t.Fatalf("round %d: %d runs started after Stop returned", i, after-before)
+		}
+	}
+	if err := timer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if timer.Logger.(*testLogger).contains("closed pool") {
+		t.Fatal("a run used the pool after it was closed")
+	}
+}
+
+func TestStopContextWithHungConnection(t *testing.T) {
+	s := newFakeRedis(t)
+	timer := newTestTimer(t, s, func(config *utils.Config) {
+		config.Delayer.TimerInterval = 10
+	})
+	var hung int32
+	s.setHook(func(cmd string, args []string) error {
+		if cmd == "ZRANGEBYSCORE" || cmd == "EVAL" {
+			atomic.StoreInt32(&hung, 1)
+			return errHang
+		}
+		return nil
+	})
+	timer.Start()
+	waitFor(t, time.Second, "tick did not reach redis", func() bool {
+		return atomic.LoadInt32(&hung) == 1
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := timer.StopContext(ctx)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected the deadline error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("expected StopContext to return at the deadline, took %s", elapsed)
+	}
+	// 超过期限返回时同样写出缓冲中的日志
+	if n := timer.Logger.(*testLogger).flushed(); n != 1 {
+		t.Fatalf("expected Flush on a timed out stop, got %d calls", n)
+	}
+	// 关闭连接后阻塞中的执行结束, 可再次启动
+	s.setHook(nil)
+	s.addJob("a", "mail", time.Now().Unix()-1)
+	timer.Start()
+	waitFor(t, time.Second, "timer did not resume after the hung run", func() bool {
+		return len(s.queue("mail")) == 1
+	})
+}
+
+func TestStartupDelay(t *testing.T) {
+	s := newFakeRedis(t)
+	s.addJob("a", "mail", time.Now().Unix()-1)
+	timer := newTestTimer(t, s, func(config *utils.Config) {
+		config.Delayer.TimerInterval = 10
+		config.Delayer.StartupDelay = 100
+	})
+	start := time.Now()
+	timer.Start()
+	waitFor(t, time.Second, "job was not moved after the startup delay", func() bool {
+		return len(s.queue("mail")) == 1
+	})
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Fatalf("expected the first tick after at least 100ms, got %s", elapsed)
+	}
+}
+
+func TestStopDuringStartupDelay(t *testing.T) {
+	s := newFakeRedis(t)
+	timer := newTestTimer(t, s, func(config *utils.Config) {
+		config.Delayer.TimerInterval = 10
+		config.Delayer.StartupDelay = 60000
+	})
+	timer.Start()
+	start := time.Now()
+	timer.Stop()
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("expected Stop to interrupt the startup delay, took %s", elapsed)
+	}
+	if n := scans(s); n != 0 {
+		t.Fatalf("expected no runs, got %d", n)
+	}
+}
+
+func TestStopFlushesLogger(t *testing.T) {
+	s := newFakeRedis(t)
+	s.addJob("a", "mail", time.Now().Unix()-1)
+	timer := newTestTimer(t, s, func(config *utils.Config) {
+		config.Delayer.TimerInterval = 10
+	})
+	logger := timer.Logger.(*testLogger)
+	timer.Start()
+	waitFor(t, time.Second, "job was not moved", func() bool {
+		return len(s.queue("mail")) == 1
+	})
+	if n := logger.flushed(); n != 0 {
+		t.Fatalf("expected no Flush while running, got %d calls", n)
+	}
+	timer.Stop()
+	if n := logger.flushed(); n != 1 {
+		t.Fatalf("expected Flush on stop, got %d calls", n)
+	}
+	// 未运行时停止不重复刷新
+	timer.Stop()
+	if n := logger.flushed(); n != 1 {
+		t.Fatalf("expected no Flush when already stopped, got %d calls", n)
+	}
+}
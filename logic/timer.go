@@ -1,8 +1,12 @@
 package logic
 
 import (
+	"context"
 	"fmt"
+	"math/rand"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/dcsunny/delayer/utils"
@@ -16,156 +20,840 @@ type Timer struct {
 	Ticker      *time.Ticker
 	Pool        *redis.Pool
 	HandleError func(err error, funcName string, data string)
+	// 任务就绪前的处理钩子, 可修改 bucket 字段, 返回错误则该任务本次不移动
+	BeforeReady func(job *Job) error
+	// 任务移动前的判断, 返回 false 则该任务留在JobPool中
+	ShouldMove func(job *Job) bool
+	// ShouldMove 返回 false 时重新调度的延迟, 为 0 时推迟一个计算间隔
+	SkipDelay time.Duration
+	// 任务就绪时回调调度延迟, 即实际就绪时间与计划时间之差
+	OnSchedulingLatency func(topic string, lag time.Duration)
+	// 清理没有Bucket的任务时回调
+	OnOrphan func(jobID string)
+	// 单次执行耗时超过间隔时回调
+	OnTickOverrun func(elapsed time.Duration)
+	// 单次执行结束时回调, 失败时 err 为 *TickError
+	OnTick func(err error)
+	// 任务就绪后回调, 未通过 OnTopicReady 注册对应Topic时使用
+	OnJobReady func(topic string, ids []string)
+	// 任务被拒绝并放入死信队列时回调
+	OnDeadLetter func(topic string, ids []string, reason string)
+	// 连接失败时回调, 限流为每 DIAL_ERROR_INTERVAL 一次
+	OnDialError func(err error)
+	// ReadyQueue中最早任务的等待时间超过 queue_age_threshold 时回调
+	OnQueueLag func(topic string, age time.Duration)
+	// 处理错误时回调, 附带错误分类, 单次执行的汇总错误按首个错误分类
+	OnError func(category ErrorCategory, err error, funcName string, data string)
+
+	backoff    topicBackoff
+	topicCache *topicCache
+	// 已创建ReadyQueue的Topic, 用于限制Topic数量, 定期清空以感知注销
+	knownTopics     map[string]bool
+	knownTopicsTime time.Time
+	keys            Keys
+	errs            *TickError
+	readies         *readyBatch
+	// 追赶积压时每次移动的任务数
+	catchUpLimit int
+	caughtUp     bool
+	readyMutex   sync.RWMutex
+	topicReady   map[string]func(ids []string)
+	readyCount   uint64
+	movedCount   uint64
+	tickCount    uint64
+	stop         chan bool
+	// 本次运行结束时关闭, 停止超时后再次启动时等待上次运行结束
+	done chan bool
+	// 连接池切换互斥
+	poolMutex sync.RWMutex
+	conns     connTracker
+	// 停止超时已关闭连接池, 再次启动时重建
+	poolClosed int32
+	// 启动与停止互斥, 停止标记置位后不再开始新的执行
+	stateMutex sync.Mutex
+	stopping   int32
+	// 出现主从切换错误, 本次执行结束后重建连接池
+	redialPending int32
+	// 待生效的连接池大小
+	limitsMutex  sync.Mutex
+	poolLimits   []int
+	dialThrottle dialThrottle
+	queueAgeTime time.Time
 }
 
 const (
 	KEY_JOB_POOL       = "delayer:job_pool"
+	KEY_RETRY_POOL     = "delayer:retry_pool"
+	KEY_QUARANTINE     = "delayer:quarantine"
+	KEY_HELD_TOPICS    = "delayer:held_topics"
+	KEY_TOPICS         = "delayer:topics"
 	PREFIX_JOB_BUCKET  = "delayer:job_bucket:"
 	PREFIX_READY_QUEUE = "delayer:ready_queue:"
+	PREFIX_DEAD_QUEUE  = "delayer:dead_queue:"
+	// 暂停Topic的任务暂存, 保留原就绪时间
+	PREFIX_HELD_POOL = "delayer:held_pool:"
+	// 通知频道, 消息内容为逗号分隔的任务ID
+	PREFIX_NOTIFY_CHANNEL = "delayer:notify:"
+	// 单次执行最多取出的任务数
+	MAX_BATCH_SIZE = 10000
+	// 获取Topic的最大并发数
+	MAX_LOOKUP_CONCURRENCY = 64
+	// 配置了时间预算时单个Topic每批移动的任务数, 每批前检查预算
+	TICK_BUDGET_CHUNK = 100
 )
 
-// 初始化
-func (p *Timer) Init() {
-	pool := &redis.Pool{
-		Dial: func() (redis.Conn, error) {
-			c, err := redis.Dial("tcp", p.Config.Redis.Host+":"+p.Config.Redis.Port)
-			if err != nil {
-				return nil, err
-			}
-			if p.Config.Redis.Password != "" {
-				if _, err := c.Do("AUTH", p.Config.Redis.Password); err != nil {
-					c.Close()
-					return nil, err
-				}
-			}
-			if _, err := c.Do("SELECT", p.Config.Redis.Database); err != nil {
-				c.Close()
-				return nil, err
-			}
-			return c, nil
-		},
-		MaxIdle:         p.Config.Redis.MaxIdle,
-		MaxActive:       p.Config.Redis.MaxActive,
-		IdleTimeout:     time.Duration(p.Config.Redis.IdleTimeout) * time.Second,
-		MaxConnLifetime: time.Duration(p.Config.Redis.ConnMaxLifetime) * time.Second,
-	}
-	p.Pool = pool
+// 初始化, 配置错误时返回 ConfigError, 网络等临时错误仅记录日志
+func (p *Timer) Init() error {
 	handleError := func(err error, funcName string, data string) {
 		if err != nil {
+			logger := utils.WithFields(p.Logger, utils.F("func", funcName), utils.F("category", string(Categorize(err))), utils.F("error", err.Error()), utils.F("data", data))
 			if data != "" {
 				data = ", [" + data + "]"
 			}
-			p.Logger.Error(fmt.Sprintf("FAILURE: func %s, %s%s.", funcName, err.Error(), data), false)
+			logger.Error(fmt.Sprintf("FAILURE: [%s] func %s, %s%s.", Categorize(err), funcName, err.Error(), data), false)
 		}
 	}
 	p.HandleError = handleError
+	if err := p.Config.Validate(); err != nil {
+		return &ConfigError{Err: err}
+	}
+	p.keys = NewKeys(p.Config.Delayer.Namespace)
+	password, err := readPassword(p.Config)
+	if err != nil {
+		return err
+	}
+	pool := p.newPool(p.Config, password)
+	p.setPool(pool)
+	if p.Config.Delayer.TopicCacheSize > 0 {
+		p.topicCache = newTopicCache(p.Config.Delayer.TopicCacheSize)
+	}
+	// 预先校验连接
+	if err := checkPool(pool); err != nil {
+		if IsConfigError(err) {
+			return err
+		}
+		p.handleError(err, "Init", "")
+		return nil
+	}
+	p.checkClockSkew()
+	if p.Config.Delayer.QueueAgeThreshold > 0 && p.Config.Redis.Compat {
+		p.Logger.Warn("queue_age_threshold requires EVAL, ready_at is not recorded in compat mode")
+	}
+	return p.checkKeyTypes()
+}
+
+// 校验核心键的类型, 被其他程序误写时返回错误
+func (p *Timer) checkKeyTypes() error {
+	conn := p.pool().Get()
+	defer conn.Close()
+	keyType, err := redis.String(conn.Do("TYPE", p.keys.JobPool))
+	if err != nil {
+		p.handleError(err, "checkKeyTypes", p.keys.JobPool)
+		return nil
+	}
+	if keyType != "zset" && keyType != "none" {
+		return &ConfigError{Err: fmt.Errorf("key %s has type %s, expected zset", p.keys.JobPool, keyType)}
+	}
+	return nil
 }
 
 // 开始
 func (p *Timer) Start() {
+	if p.Config.Delayer.Disabled {
+		p.Logger.Info("Timer is disabled, no jobs will be moved")
+		return
+	}
+	p.stateMutex.Lock()
+	defer p.stateMutex.Unlock()
+	if p.Ticker != nil {
+		return
+	}
+	atomic.StoreInt32(&p.stopping, 0)
 	ticker := time.NewTicker(time.Duration(p.Config.Delayer.TimerInterval) * time.Millisecond)
+	stop := make(chan bool)
+	done := make(chan bool)
+	prev := p.done
 	go func() {
-		for range ticker.C {
-			p.run()
+		defer close(done)
+		// 上次停止超时时, 等待其执行结束后再开始
+		if prev != nil {
+			select {
+			case <-prev:
+			case <-stop:
+				return
+			}
+		}
+		if atomic.CompareAndSwapInt32(&p.poolClosed, 1, 0) {
+			p.resetPool()
+		}
+		if !p.startupDelay(stop) {
+			return
+		}
+		p.catchUp(stop)
+		for {
+			select {
+			case <-ticker.C:
+				// 停止时可能已有到达的信号, 不再开始新的执行
+				if p.isStopping() {
+					return
+				}
+				p.tick()
+			case <-stop:
+				return
+			}
 		}
 	}()
 	p.Ticker = ticker
+	p.stop = stop
+	p.done = done
+}
+
+// 首次执行前等待 startup_delay 并附加至多一半的随机抖动, 错开多实例的首次扫描, 停止时返回 false
+func (p *Timer) startupDelay(stop chan bool) bool {
+	delay := time.Duration(p.Config.Delayer.StartupDelay) * time.Millisecond
+	if delay <= 0 {
+		return true
+	}
+	// 各实例使用独立的随机源, 避免默认源未初始化时抖动相同
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+	delay += time.Duration(random.Int63n(int64(delay)/2 + 1))
+	p.Logger.Debug(fmt.Sprintf("Timer waiting before first tick, delay: %s", delay))
+	select {
+	case <-stop:
+		return false
+	case <-time.After(delay):
+		return true
+	}
+}
+
+// 重启, 使用新配置重建连接池与定时器, 新配置无效时保持原定时器运行
+func (p *Timer) Restart(config utils.Config) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	password, err := readPassword(config)
+	if err != nil {
+		return err
+	}
+	pool := p.newPool(config, password)
+	if err := checkPool(pool); err != nil {
+		pool.Close()
+		return err
+	}
+	// 等待执行中的任务完成后切换
+	p.Stop()
+	old := p.pool()
+	p.Config = config
+	p.setPool(pool)
+	p.keys = NewKeys(config.Delayer.Namespace)
+	old.Close()
+	p.topicCache = nil
+	if config.Delayer.TopicCacheSize > 0 {
+		p.topicCache = newTopicCache(config.Delayer.TopicCacheSize)
+	}
+	p.knownTopics = nil
+	p.Start()
+	return nil
+}
+
+// 单次执行, 耗时超过间隔时记录
+func (p *Timer) tick() {
+	start := time.Now()
+	atomic.AddUint64(&p.tickCount, 1)
+	err := p.run()
+	if err != nil {
+		p.handleError(err, "run", "")
+	}
+	if p.OnTick != nil {
+		p.OnTick(err)
+	}
+	p.checkQueueAges()
+	// 停止中不再重建连接池, 避免与停止超时时的关闭交错
+	if !p.isStopping() {
+		if atomic.CompareAndSwapInt32(&p.redialPending, 1, 0) {
+			p.Logger.Warn("Redis failover detected, redialing")
+			p.resetPool()
+		} else if p.applyPoolLimits() {
+			p.resetPool()
+		}
+	}
+	elapsed := time.Since(start)
+	p.Logger.Debug(fmt.Sprintf("Tick finished, elapsed: %s", elapsed))
+	interval := time.Duration(p.Config.Delayer.TimerInterval) * time.Millisecond
+	if elapsed <= interval {
+		return
+	}
+	logger := utils.WithFields(p.Logger, utils.F("elapsed", elapsed), utils.F("interval", interval))
+	logger.Warn(fmt.Sprintf("Timer overrun, elapsed: %s, interval: %s", elapsed, interval))
+	if p.OnTickOverrun != nil {
+		p.OnTickOverrun(elapsed)
+	}
+}
+
+// 处理错误, 并按分类回调 OnError
+func (p *Timer) handleError(err error, funcName string, data string) {
+	p.HandleError(err, funcName, data)
+	if p.OnError != nil {
+		p.OnError(Categorize(err), err, funcName, data)
+	}
 }
 
 // 执行任务
-func (p *Timer) run() {
+func (p *Timer) run() error {
+	errs := &TickError{}
+	p.errs = errs
+	defer func() {
+		p.errs = nil
+	}()
+	p.execute()
+	if errs.Total > 0 {
+		return errs
+	}
+	return nil
+}
+
+// 记录错误, 执行中汇总至本次执行的错误, 否则直接处理
+func (p *Timer) fail(err error, funcName string, data string) {
+	if err == nil {
+		return
+	}
+	if p.Config.Redis.Redial && isFailoverError(err) {
+		atomic.StoreInt32(&p.redialPending, 1)
+	}
+	if p.errs != nil {
+		p.errs.add(err, funcName, data)
+		return
+	}
+	p.handleError(err, funcName, data)
+}
+
+// 移动到期的任务
+func (p *Timer) execute() {
+	readies := &readyBatch{}
+	p.readies = readies
+	defer func() {
+		p.readies = nil
+		for _, move := range readies.sorted() {
+			p.notifyReady(move)
+		}
+	}()
+	// 放回重试的任务
+	p.drainRetry()
+	// 隔离就绪时间异常的任务
+	p.quarantine()
 	// 获取到期的任务
-	jobs, err := p.getExpireJobs()
+	jobs, scores, err := p.getExpireJobs()
 	if err != nil {
-		p.HandleError(err, "getExpireJobs", "")
+		p.fail(err, "getExpireJobs", "")
 		return
 	}
-	// 并行获取Topic
-	topics := make(map[string][]string)
-	ch := make(chan []string)
-	for _, jobID := range jobs {
-		go p.getJobTopic(jobID, ch)
+	// 按Topic分组
+	topics := p.jobTopics(jobs)
+	// 公平分配时继续扫描, 使各Topic都能找到候选任务
+	if p.fairScheduling() {
+		p.scanFairCandidates(topics, scores, len(jobs))
 	}
-	// Topic分组
-	for i := 0; i < len(jobs); i++ {
-		arr := <-ch
-		if arr[1] != "" {
-			if _, ok := topics[arr[1]]; !ok {
-				jobIDs := []string{arr[0]}
-				topics[arr[1]] = jobIDs
-			} else {
-				topics[arr[1]] = append(topics[arr[1]], arr[0])
-			}
+	// 暂停的Topic的任务移至暂存集合, 避免占用后续扫描
+	for topic := range p.heldTopics() {
+		if jobIDs, ok := topics[topic]; ok {
+			p.holdJobs(topic, jobIDs, scores)
+			delete(topics, topic)
+		}
+	}
+	// 限制Topic数量
+	topics = p.limitTopics(topics)
+	// 公平分配
+	if p.fairScheduling() {
+		topics = fairShare(topics, scores, p.Config.Delayer.TopicWeights, p.Config.Delayer.BatchSize)
+	}
+	// 并行移动至Topic对应的ReadyQueue, 跳过退避中的Topic, 按Topic与就绪时间排序以保证顺序稳定
+	interval := time.Duration(p.Config.Delayer.TimerInterval) * time.Millisecond
+	deadline := time.Now().Add(interval)
+	budget := time.Duration(p.Config.Delayer.TickBudget) * time.Millisecond
+	if budget > 0 && budget < interval {
+		deadline = time.Now().Add(budget)
+	}
+	groupSize := p.Config.Delayer.MoveGroupSize
+	// 跨槽位时无法使用事务, 逐个Topic分步移动
+	if p.Config.Redis.CrossSlot {
+		groupSize = 0
+	}
+	g := newRunGroup(p.concurrency(MAX_MOVE_CONCURRENCY))
+	group := make(map[string][]string)
+	for _, topic := range sortedTopics(topics) {
+		topic := topic
+		jobIDs := topics[topic]
+		sortJobIDs(jobIDs, scores)
+		// 退避中的Topic暂存至RetryPool, 避免其任务占用后续扫描
+		if !p.backoff.allow(topic) {
+			p.park(jobIDs, time.Until(p.backoff.resume(topic)))
+			continue
+		}
+		// 超出时间预算, 剩余任务留待下次执行
+		if budget > 0 && !time.Now().Before(deadline) {
+			p.Logger.Debug(fmt.Sprintf("Tick budget exhausted, budget: %s", budget))
+			break
 		}
+		if groupSize <= 1 {
+			g.Go(func() {
+				p.moveTopic(jobIDs, topic, scores, interval, deadline, budget)
+			})
+			continue
+		}
+		group[topic] = jobIDs
+		if len(group) >= groupSize {
+			moves := group
+			g.Go(func() {
+				p.moveGroup(moves, scores, interval, deadline, budget)
+			})
+			group = make(map[string][]string)
+		}
+	}
+	if len(group) > 0 {
+		g.Go(func() {
+			p.moveGroup(group, scores, interval, deadline, budget)
+		})
+	}
+	// 等待全部移动完成
+	g.Wait()
+}
+
+// 移动单个Topic, 配置了时间预算时分批移动, 超出预算后剩余任务留待下次执行
+func (p *Timer) moveTopic(jobIDs []string, topic string, scores map[string]int64, interval time.Duration, deadline time.Time, budget time.Duration) {
+	size := len(jobIDs)
+	if budget > 0 && size > TICK_BUDGET_CHUNK {
+		size = TICK_BUDGET_CHUNK
 	}
-	// 并行移动至Topic对应的ReadyQueue
-	for topic, jobIDs := range topics {
-		go p.moveJobToReadyQueue(jobIDs, topic)
+	ok := true
+	for start := 0; start < len(jobIDs) && ok; start += size {
+		if budget > 0 && !time.Now().Before(deadline) {
+			p.Logger.Debug(fmt.Sprintf("Tick budget exhausted, budget: %s, topic: %s", budget, topic))
+			break
+		}
+		end := start + size
+		if end > len(jobIDs) {
+			end = len(jobIDs)
+		}
+		chunk := jobIDs[start:end]
+		ok = p.moveJobToReadyQueue(chunk, topic, scores)
+		if !ok {
+			ok = p.retryMove(chunk, topic, scores, deadline)
+		}
+		if !ok {
+			p.parkRetry(chunk)
+		}
 	}
+	p.backoff.done(topic, ok, interval)
+}
+
+// 分组移动, 等待并发时已超出时间预算则留待下次执行
+func (p *Timer) moveGroup(group map[string][]string, scores map[string]int64, interval time.Duration, deadline time.Time, budget time.Duration) {
+	if budget > 0 && !time.Now().Before(deadline) {
+		p.Logger.Debug(fmt.Sprintf("Tick budget exhausted, budget: %s", budget))
+		return
+	}
+	for topic, ok := range p.moveTopicsToReadyQueue(group, scores) {
+		if !ok {
+			ok = p.retryMove(group[topic], topic, scores, deadline)
+		}
+		if !ok {
+			p.parkRetry(group[topic])
+		}
+		p.backoff.done(topic, ok, interval)
+	}
+}
+
+// 并发数, 不超过连接池容量的一半, 每个并发任务可能在持有连接时再获取一个连接 (死信, 暂存等)
+func (p *Timer) concurrency(limit int) int {
+	maxActive := p.Config.Redis.MaxActive
+	if maxActive <= 0 {
+		return limit
+	}
+	if n := maxActive / 2; n < limit {
+		limit = n
+	}
+	if limit < 1 {
+		limit = 1
+	}
+	return limit
+}
+
+// 单次扫描的任务数
+func (p *Timer) scanLimit() int {
+	// 未配置或超出上限时使用上限, 避免一次取出过多任务
+	limit := p.Config.Delayer.BatchSize
+	if p.catchUpLimit > 0 {
+		limit = p.catchUpLimit
+	}
+	if limit <= 0 || limit > MAX_BATCH_SIZE {
+		limit = MAX_BATCH_SIZE
+	}
+	// 公平分配时多取一些, 以便各Topic都有候选任务
+	if p.fairScheduling() {
+		limit *= FAIR_SCAN_FACTOR
+	}
+	return limit
 }
 
 // 获取到期的任务
-func (p *Timer) getExpireJobs() ([]string, error) {
-	conn := p.Pool.Get()
+func (p *Timer) getExpireJobs() ([]string, map[string]int64, error) {
+	conn := p.pool().Get()
 	defer conn.Close()
-	return redis.Strings(conn.Do("ZRANGEBYSCORE", KEY_JOB_POOL, "0", time.Now().Unix()))
+	limit := p.scanLimit()
+	var scores map[string]int64
+	var err error
+	if p.Config.Delayer.ServerTime && !p.Config.Redis.Compat {
+		scores, err = scoreMap(expireJobsScript.Do(conn, p.keys.JobPool, limit))
+	} else {
+		// 兼容模式下不使用 EVAL, 先取服务器时间再查询
+		args := redis.Args{}.Add(p.keys.JobPool, "0", p.now(conn), "WITHSCORES", "LIMIT", 0, limit)
+		scores, err = scoreMap(conn.Do("ZRANGEBYSCORE", args...))
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	jobs := make([]string, 0, len(scores))
+	for jobID := range scores {
+		jobs = append(jobs, jobID)
+	}
+	return jobs, scores, nil
+}
+
+// 并行获取任务的Topic并分组, 限制并发数, 无法确定Topic的任务不返回
+func (p *Timer) jobTopics(jobs []string) map[string][]string {
+	topics := make(map[string][]string)
+	ch := make(chan []string, len(jobs))
+	sem := make(chan bool, p.concurrency(MAX_LOOKUP_CONCURRENCY))
+	for _, jobID := range jobs {
+		sem <- true
+		go func(jobID string) {
+			p.getJobTopic(jobID, ch)
+			<-sem
+		}(jobID)
+	}
+	for i := 0; i < len(jobs); i++ {
+		arr := <-ch
+		if arr[1] != "" {
+			topics[arr[1]] = append(topics[arr[1]], arr[0])
+		}
+	}
+	return topics
 }
 
 // 获取任务的Topic
 func (p *Timer) getJobTopic(jobID string, ch chan []string) {
-	conn := p.Pool.Get()
-	defer conn.Close()
-	topic, err := redis.Strings(conn.Do("HMGET", PREFIX_JOB_BUCKET+jobID, "topic"))
-	if err != nil {
-		if err == redis.ErrNil {
-			// 删除delayer:job_pool里面的jobid
-			conn.Do("HDEL", KEY_JOB_POOL, jobID)
+	if p.topicCache != nil {
+		if topic, ok := p.topicCache.get(jobID); ok {
+			ch <- []string{jobID, topic}
 			return
 		}
-		p.HandleError(err, "getJobTopic", jobID)
+	}
+	conn := p.pool().Get()
+	defer conn.Close()
+	topic, err := redis.Strings(conn.Do("HMGET", p.keys.JobBucket+jobID, p.groupField()))
+	if err != nil {
+		p.fail(err, "getJobTopic", jobID)
 		ch <- []string{jobID, ""}
 		return
 	}
+	if topic[0] == "" {
+		// Bucket存在但Topic为空时按 empty_topic 处理, 避免每次扫描都留在JobPool中
+		if exists, err := p.removeOrphan(conn, jobID); err == nil && exists {
+			topic[0] = p.emptyTopic(jobID)
+		}
+	}
+	if topic[0] != "" && p.topicCache != nil {
+		p.topicCache.add(jobID, topic[0])
+	}
 	arr := []string{jobID, topic[0]}
 	ch <- arr
 }
 
+// 分组字段, 默认为 topic
+func (p *Timer) groupField() string {
+	if p.Config.Delayer.GroupField == "" {
+		return FIELD_TOPIC
+	}
+	return p.Config.Delayer.GroupField
+}
+
+// 清理没有Bucket的任务, 返回Bucket是否存在
+func (p *Timer) removeOrphan(conn redis.Conn, jobID string) (bool, error) {
+	exists, err := redis.Bool(conn.Do("EXISTS", p.keys.JobBucket+jobID))
+	if err != nil || exists {
+		return exists, err
+	}
+	// 删除delayer:job_pool里面的jobid
+	if _, err := conn.Do("ZREM", p.keys.JobPool, jobID); err != nil {
+		p.fail(err, "removeOrphan", jobID)
+		return false, err
+	}
+	if p.topicCache != nil {
+		p.topicCache.remove(jobID)
+	}
+	if p.OnOrphan != nil {
+		p.OnOrphan(jobID)
+	}
+	return false, nil
+}
+
+// Topic为空的任务, 配置了 empty_topic 时放入该Topic, 否则放入拒绝死信队列
+func (p *Timer) emptyTopic(jobID string) string {
+	if p.Config.Delayer.EmptyTopic != "" {
+		return p.Config.Delayer.EmptyTopic
+	}
+	p.deadLetter([]string{jobID}, "", "empty topic")
+	return ""
+}
+
 // 移动任务至ReadyQueue
-func (p *Timer) moveJobToReadyQueue(jobIDs []string, topic string) {
+func (p *Timer) moveJobToReadyQueue(jobIDs []string, topic string, scores map[string]int64) bool {
+	if p.Config.Redis.CrossSlot {
+		return p.moveAcrossSlots(jobIDs, topic, scores)
+	}
 	// 获取连接
-	conn := p.Pool.Get()
+	conn := p.pool().Get()
+	defer conn.Close()
+	// 就绪前处理
+	jobIDs, changes := p.beforeReady(conn, jobIDs)
+	if len(jobIDs) == 0 {
+		return true
+	}
+	// 发送事务命令
+	if _, ok := p.sendMove(conn, jobIDs, topic, changes); !ok {
+		return false
+	}
+	// 提交事物
+	values, err := p.commit(conn)
+	return p.moved(jobIDs, topic, scores, len(changes)+1, values, err)
+}
+
+// 在同一连接上移动多个Topic, 每个Topic独立事务, 一次发送
+func (p *Timer) moveTopicsToReadyQueue(group map[string][]string, scores map[string]int64) map[string]bool {
+	results := make(map[string]bool, len(group))
+	conn := p.pool().Get()
 	defer conn.Close()
+	// 就绪前处理需在发送事务前完成, 避免与未读取的回复交错
+	moves := make(map[string][]string)
+	changes := make(map[string]map[string]map[string]string)
+	for topic, jobIDs := range group {
+		jobIDs, c := p.beforeReady(conn, jobIDs)
+		if len(jobIDs) == 0 {
+			results[topic] = true
+			continue
+		}
+		moves[topic] = jobIDs
+		changes[topic] = c
+	}
+	var topics []string
+	pending := make(map[string]int)
+	for _, topic := range sortedTopics(moves) {
+		jobIDs := moves[topic]
+		n, ok := p.sendMove(conn, jobIDs, topic, changes[topic])
+		if ok {
+			if err := conn.Send("EXEC"); err != nil {
+				p.fail(err, "commit", strings.Join(jobIDs, ","))
+				ok = false
+			}
+		}
+		if !ok {
+			// 连接已不可用, 未完成的Topic均视为失败
+			for topic := range moves {
+				results[topic] = false
+			}
+			return results
+		}
+		topics = append(topics, topic)
+		pending[topic] = n
+	}
+	if err := conn.Flush(); err != nil {
+		p.fail(err, "flush", "")
+		for _, topic := range topics {
+			results[topic] = false
+		}
+		return results
+	}
+	// 按发送顺序接收结果
+	for _, topic := range topics {
+		for i := 0; i < pending[topic]; i++ {
+			conn.Receive()
+		}
+		values, err := redis.Values(conn.Receive())
+		results[topic] = p.moved(moves[topic], topic, scores, len(changes[topic])+1, values, err)
+	}
+	return results
+}
+
+// 发送移动事务, 返回已发送的命令数 (不含 EXEC)
+func (p *Timer) sendMove(conn redis.Conn, jobIDs []string, topic string, changes map[string]map[string]string) (int, bool) {
 	jobIDsStr := strings.Join(jobIDs, ",")
 	// 开启事物
 	if err := p.startTrans(conn); err != nil {
-		p.HandleError(err, "startTrans", jobIDsStr)
-		return
+		p.fail(err, "startTrans", jobIDsStr)
+		return 0, false
 	}
 	// 移除JobPool
 	if err := p.delJobPool(conn, jobIDs, topic); err != nil {
-		p.HandleError(err, "delJobPool", jobIDsStr)
-		return
+		p.fail(err, "delJobPool", jobIDsStr)
+		return 0, false
+	}
+	// 更新Bucket
+	if err := p.updateJobBuckets(conn, changes); err != nil {
+		p.fail(err, "updateJobBuckets", jobIDsStr)
+		return 0, false
 	}
 	// 插入ReadyQueue
 	if err := p.addReadyQueue(conn, jobIDs, topic); err != nil {
-		p.HandleError(err, "addReadyQueue", jobIDsStr)
-		return
+		p.fail(err, "addReadyQueue", jobIDsStr)
+		return 0, false
 	}
-	// 提交事物
-	values, err := p.commit(conn)
+	n := len(changes) + 3
+	// 记录进入ReadyQueue的时间, 脚本出错不影响事务中的其他命令
+	if p.stampsReadyAt() {
+		if err := p.sendReadyAt(conn, jobIDs); err != nil {
+			p.fail(err, "sendReadyAt", jobIDsStr)
+			return 0, false
+		}
+		n++
+	}
+	// 通知, 与插入在同一事务中, 订阅方收到时任务已在ReadyQueue中
+	if p.Config.Delayer.Notify {
+		if err := conn.Send("PUBLISH", p.keys.NotifyChannel+topic, jobIDsStr); err != nil {
+			p.fail(err, "publish", jobIDsStr)
+			return 0, false
+		}
+		n++
+	}
+	return n, true
+}
+
+// 事务结果处理
+func (p *Timer) moved(jobIDs []string, topic string, scores map[string]int64, pushIndex int, values []interface{}, err error) bool {
+	jobIDsStr := strings.Join(jobIDs, ",")
 	if err != nil {
-		p.HandleError(err, "commit", jobIDsStr)
-		return
+		p.fail(err, "commit", jobIDsStr)
+		return false
+	}
+	v, err := redis.Int64(values[0], nil)
+	if err != nil {
+		p.fail(err, "commit", jobIDsStr)
+		return false
+	}
+	v1, err := redis.Int64(values[pushIndex], nil)
+	if err != nil {
+		p.fail(err, "commit", jobIDsStr)
+		return false
 	}
-	// 事务结果处理
-	v := values[0].(int64)
-	v1 := values[1].(int64)
+	// 任务已被其他实例移出JobPool, 不视为失败
 	if v == 0 || v1 == 0 {
-		p.HandleError(err, "commit", jobIDsStr)
+		return true
+	}
+	p.ready(jobIDs, topic, scores)
+	return true
+}
+
+// 移动成功后的处理, 执行中的日志与回调在全部移动结束后按Topic顺序触发
+func (p *Timer) ready(jobIDs []string, topic string, scores map[string]int64) {
+	atomic.AddUint64(&p.movedCount, uint64(len(jobIDs)))
+	if p.topicCache != nil {
+		p.topicCache.remove(jobIDs...)
+	}
+	move := readyMove{topic: topic, jobIDs: jobIDs, scores: scores, at: time.Now()}
+	if p.readies != nil {
+		p.readies.add(move)
+		return
+	}
+	p.notifyReady(move)
+}
+
+// 打印就绪日志并回调
+func (p *Timer) notifyReady(move readyMove) {
+	topic, jobIDs := move.topic, move.jobIDs
+	// 打印日志, 按采样率输出
+	if n := p.Config.Delayer.ReadyLogSampleN; n <= 1 || atomic.AddUint64(&p.readyCount, 1)%uint64(n) == 0 {
+		logger := utils.WithFields(p.Logger, utils.F("topic", topic), utils.F("ids", jobIDs))
+		logger.Info(fmt.Sprintf("Job is ready, Topic: %s, IDs: [%s]", topic, strings.Join(jobIDs, ",")))
+	}
+	p.jobReady(topic, jobIDs)
+	// 调度延迟, 按移动完成的时间计算
+	if p.OnSchedulingLatency != nil {
+		for _, jobID := range jobIDs {
+			p.OnSchedulingLatency(topic, move.at.Sub(time.Unix(move.scores[jobID], 0)))
+		}
+	}
+}
+
+// 注册Topic的就绪回调
+func (p *Timer) OnTopicReady(topic string, fn func(ids []string)) {
+	p.readyMutex.Lock()
+	defer p.readyMutex.Unlock()
+	if p.topicReady == nil {
+		p.topicReady = make(map[string]func(ids []string))
+	}
+	p.topicReady[topic] = fn
+}
+
+// 触发就绪回调
+func (p *Timer) jobReady(topic string, jobIDs []string) {
+	p.readyMutex.RLock()
+	fn, ok := p.topicReady[topic]
+	p.readyMutex.RUnlock()
+	if ok {
+		fn(jobIDs)
 		return
 	}
-	// 打印日志
-	p.Logger.Info(fmt.Sprintf("Job is ready, Topic: %s, IDs: [%s]", topic, jobIDsStr))
+	if p.OnJobReady != nil {
+		p.OnJobReady(topic, jobIDs)
+	}
+}
+
+// 就绪前处理, 返回可移动的任务与需更新的 bucket 字段
+func (p *Timer) beforeReady(conn redis.Conn, jobIDs []string) ([]string, map[string]map[string]string) {
+	changes := make(map[string]map[string]string)
+	if p.BeforeReady == nil && p.ShouldMove == nil {
+		return jobIDs, changes
+	}
+	var ready []string
+	for _, jobID := range jobIDs {
+		fields, err := redis.StringMap(conn.Do("HGETALL", p.keys.JobBucket+jobID))
+		if err != nil {
+			p.fail(err, "beforeReady", jobID)
+			continue
+		}
+		origin := make(map[string]string, len(fields))
+		for k, v := range fields {
+			origin[k] = v
+		}
+		job := newJob(jobID, fields)
+		if p.ShouldMove != nil && !p.ShouldMove(job) {
+			p.skipJob(conn, jobID)
+			continue
+		}
+		if p.BeforeReady == nil {
+			ready = append(ready, jobID)
+			continue
+		}
+		if err := p.BeforeReady(job); err != nil {
+			p.fail(err, "beforeReady", jobID)
+			continue
+		}
+		changed := make(map[string]string)
+		for k, v := range job.Fields {
+			if o, ok := origin[k]; !ok || o != v {
+				changed[k] = v
+			}
+		}
+		for k, v := range job.metaFields() {
+			if o, ok := origin[k]; !ok || o != v {
+				changed[k] = v
+			}
+		}
+		if len(changed) > 0 {
+			changes[jobID] = changed
+		}
+		ready = append(ready, jobID)
+	}
+	return ready, changes
 }
 
 // 开启事务
@@ -181,24 +869,167 @@ func (p *Timer) commit(conn redis.Conn) ([]interface{}, error) {
 // 移除JobPool
 func (p *Timer) delJobPool(conn redis.Conn, jobIDs []string, topic string) error {
 	args := make([]interface{}, len(jobIDs)+1)
-	args[0] = KEY_JOB_POOL
+	args[0] = p.keys.JobPool
 	for k, v := range jobIDs {
 		args[k+1] = v
 	}
 	return conn.Send("ZREM", args...)
 }
 
+// 跳过任务, 按 SkipDelay 重新调度, 未配置时推迟一个计算间隔, 避免一直占用扫描
+func (p *Timer) skipJob(conn redis.Conn, jobID string) {
+	delay := p.SkipDelay
+	if delay <= 0 {
+		delay = time.Duration(p.Config.Delayer.TimerInterval) * time.Millisecond
+	}
+	// 就绪时间以秒为单位, 至少推迟1秒
+	seconds := int64(delay / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	score := p.now(conn) + seconds
+	if _, err := conn.Do("ZADD", p.keys.JobPool, "XX", score, jobID); err != nil {
+		p.fail(err, "skipJob", jobID)
+	}
+}
+
+// 更新Bucket
+func (p *Timer) updateJobBuckets(conn redis.Conn, changes map[string]map[string]string) error {
+	for jobID, fields := range changes {
+		args := redis.Args{}.Add(p.keys.JobBucket + jobID).AddFlat(fields)
+		if err := conn.Send("HMSET", args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // 插入ReadyQueue
 func (p *Timer) addReadyQueue(conn redis.Conn, jobIDs []string, topic string) error {
 	args := make([]interface{}, len(jobIDs)+1)
-	args[0] = PREFIX_READY_QUEUE + topic
+	args[0] = p.keys.ReadyQueue + topic
 	for k, v := range jobIDs {
 		args[k+1] = v
 	}
+	// 客户端从右侧取出, 先进先出时从左侧插入
+	if p.Config.Delayer.ReadyOrder == utils.READY_ORDER_LIFO {
+		return conn.Send("RPUSH", args...)
+	}
 	return conn.Send("LPUSH", args...)
 }
 
 // 执行
 func (p *Timer) Stop() {
+	p.StopContext(context.Background())
+}
+
+// 停止, 等待执行中的任务完成, 超过 ctx 期限时关闭连接池并返回 ctx 的错误
+func (p *Timer) StopContext(ctx context.Context) error {
+	p.stateMutex.Lock()
+	defer p.stateMutex.Unlock()
+	if p.Ticker == nil {
+		return nil
+	}
+	atomic.StoreInt32(&p.stopping, 1)
 	p.Ticker.Stop()
+	p.Ticker = nil
+	close(p.stop)
+	defer p.Logger.Flush()
+	// 等待执行中的任务完成
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		// 关闭连接池及使用中的连接, 中断阻塞中的命令
+		p.Logger.Warn("Timer stop deadline exceeded, closing pool")
+		atomic.StoreInt32(&p.poolClosed, 1)
+		p.pool().Close()
+		p.conns.closeAll()
+		return ctx.Err()
+	}
+}
+
+// 重建连接池, 使后续命令重新解析地址并建立连接, 仅在执行间隙调用
+func (p *Timer) resetPool() {
+	p.applyPoolLimits()
+	password, err := readPassword(p.Config)
+	if err != nil {
+		p.handleError(err, "resetPool", "")
+		return
+	}
+	old := p.pool()
+	p.setPool(p.newPool(p.Config, password))
+	old.Close()
+}
+
+// 创建连接池, 记录建立的连接以便停止超时时关闭
+func (p *Timer) newPool(config utils.Config, password string) *redis.Pool {
+	pool := newPool(config, password, ROLE_TIMER, p.dialError)
+	pool.Dial = p.conns.wrap(pool.Dial)
+	return pool
+}
+
+// 当前连接池
+func (p *Timer) pool() *redis.Pool {
+	p.poolMutex.RLock()
+	defer p.poolMutex.RUnlock()
+	return p.Pool
+}
+
+// 切换连接池
+func (p *Timer) setPool(pool *redis.Pool) {
+	p.poolMutex.Lock()
+	defer p.poolMutex.Unlock()
+	p.Pool = pool
+}
+
+// 运行时调整连接池大小, 运行中时在本次执行结束后重建连接池生效
+func (p *Timer) SetPoolLimits(maxIdle, maxActive int) error {
+	if maxIdle < 0 || maxActive < 0 {
+		return fmt.Errorf("invalid pool limits, max_idle: %d, max_active: %d", maxIdle, maxActive)
+	}
+	if maxActive > 0 && maxIdle > maxActive {
+		return fmt.Errorf("max_idle %d exceeds max_active %d", maxIdle, maxActive)
+	}
+	p.limitsMutex.Lock()
+	p.poolLimits = []int{maxIdle, maxActive}
+	p.limitsMutex.Unlock()
+	p.stateMutex.Lock()
+	defer p.stateMutex.Unlock()
+	if p.Ticker == nil && p.pool() != nil {
+		p.resetPool()
+	}
+	return nil
+}
+
+// 应用待生效的连接池大小, 返回是否有变更
+func (p *Timer) applyPoolLimits() bool {
+	p.limitsMutex.Lock()
+	defer p.limitsMutex.Unlock()
+	if p.poolLimits == nil {
+		return false
+	}
+	p.Config.Redis.MaxIdle = p.poolLimits[0]
+	p.Config.Redis.MaxActive = p.poolLimits[1]
+	p.poolLimits = nil
+	p.Logger.Info(fmt.Sprintf("Pool limits changed, max_idle: %d, max_active: %d", p.Config.Redis.MaxIdle, p.Config.Redis.MaxActive))
+	return true
+}
+
+// 连接失败, 限流后回调
+func (p *Timer) dialError(err error) {
+	if p.OnDialError != nil && p.dialThrottle.allow() {
+		p.OnDialError(err)
+	}
+}
+
+// 是否已请求停止
+func (p *Timer) isStopping() bool {
+	return atomic.LoadInt32(&p.stopping) == 1
+}
+
+// 停止并关闭连接池, 在最后一次执行完成后关闭
+func (p *Timer) Close() error {
+	p.Stop()
+	return p.pool().Close()
 }
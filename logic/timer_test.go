@@ -0,0 +1,538 @@
+package logic
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dcsunny/delayer/utils"
+)
+
+// 记录日志的测试日志类
+type testLogger struct {
+	mutex    sync.Mutex
+	messages []string
+	// Flush 调用次数
+	flushes int
+}
+
+func (p *testLogger) log(level string, message string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.messages = append(p.messages, level+" "+message)
+}
+
+func (p *testLogger) Debug(message string) {
+	p.log("debug", message)
+}
+
+func (p *testLogger) Info(message string) {
+	p.log("info", message)
+}
+
+func (p *testLogger) Warn(message string) {
+	p.log("warn", message)
+}
+
+func (p *testLogger) Error(message string, exit bool) {
+	p.log("error", message)
+}
+
+func (p *testLogger) Flush() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.flushes++
+}
+
+// Flush 调用次数
+func (p *testLogger) flushed() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.flushes
+}
+
+// 是否记录了包含 substr 的日志
+func (p *testLogger) contains(substr string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, message := range p.messages {
+		if strings.Contains(message, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+// 创建连接测试服务器的定时器, 不启动, configure 可修改默认配置
+func newTestTimer(t testing.TB, s *fakeRedis, configure func(config *utils.Config)) *Timer {
+	config := s.config()
+	if configure != nil {
+		configure(&config)
+	}
+	timer := &Timer{
+		Config: config,
+		Logger: &testLogger{},
+	}
+	if err := timer.Init(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		timer.Close()
+	})
+	return timer
+}
+
+// 断言ReadyQueue中的任务, 按取出顺序
+func assertQueue(t *testing.T, s *fakeRedis, topic string, expected ...string) {
+	t.Helper()
+	if expected == nil {
+		expected = []string{}
+	}
+	if actual := s.queue(topic); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("ready queue %s: expected %v, got %v", topic, expected, actual)
+	}
+}
+
+// 断言任务仍在JobPool中
+func assertPending(t *testing.T, s *fakeRedis, jobIDs ...string) {
+	t.Helper()
+	for _, jobID := range jobIDs {
+		if _, ok := s.score(KEY_JOB_POOL, jobID); !ok {
+			t.Fatalf("job %s is not in the pool", jobID)
+		}
+	}
+}
+
+func TestTimerMovesDueJobs(t *testing.T) {
+	s := newFakeRedis(t)
+	now := time.Now().Unix()
+	s.addJob("a", "mail", now-2)
+	s.addJob("b", "mail", now-1)
+	s.addJob("c", "sms", now-1)
+	s.addJob("later", "mail", now+3600)
+	timer := newTestTimer(t, s, nil)
+	timer.tick()
+	assertQueue(t, s, "mail", "a", "b")
+	assertQueue(t, s, "sms", "c")
+	assertPending(t, s, "later")
+	if _, ok := s.score(KEY_JOB_POOL, "a"); ok {
+		t.Fatal("moved job is still in the pool")
+	}
+}
+
+func TestBeforeReadyAddsFields(t *testing.T) {
+	s := newFakeRedis(t)
+	now := time.Now().Unix()
+	s.addJob("a", "mail", now-1)
+	s.addJob("broken", "mail", now-1)
+	timer := newTestTimer(t, s, nil)
+	timer.BeforeReady = func(job *Job) error {
+		if job.ID == "broken" {
+			return errors.New("enrich failed")
+		}
+		job.Fields["ready_at"] = "stamped"
+		job.Meta["trace"] = "t-1"
+		return nil
+	}
+	timer.tick()
+	// 消费方取出后读取Bucket
+	jobID, _ := s.do("RPOP", PREFIX_READY_QUEUE+"mail").(string)
+	if jobID != "a" {
+		t.Fatalf("expected job a, got %q", jobID)
+	}
+	if v := s.field(jobID, "ready_at"); v != "stamped" {
+		t.Errorf("ready_at: expected stamped, got %q", v)
+	}
+	if v := s.field(jobID, PREFIX_META+"trace"); v != "t-1" {
+		t.Errorf("meta trace: expected t-1, got %q", v)
+	}
+	assertQueue(t, s, "mail")
+	assertPending(t, s, "broken")
+}
+
+func TestShouldMoveKeepsJobPending(t *testing.T) {
+	s := newFakeRedis(t)
+	now := time.Now().Unix()
+	s.addJob("frozen", "mail", now-1)
+	s.addJob("a", "mail", now-1)
+	s.do("HSET", PREFIX_JOB_BUCKET+"frozen", PREFIX_META+"freeze", "1")
+	timer := newTestTimer(t, s, nil)
+	timer.SkipDelay = time.Minute
+	timer.ShouldMove = func(job *Job) bool {
+		return job.Meta["freeze"] != "1"
+	}
+	timer.tick()
+	assertQueue(t, s, "mail", "a")
+	assertPending(t, s, "frozen")
+	// 按 SkipDelay 重新调度, Bucket 保留
+	if score, _ := s.score(KEY_JOB_POOL, "frozen"); int64(score) < now+60 {
+		t.Fatalf("expected the skipped job rescheduled by a minute, got score %d", int64(score))
+	}
+	if v := s.field("frozen", FIELD_TOPIC); v != "mail" {
+		t.Fatalf("expected the skipped job's bucket kept, got topic %q", v)
+	}
+	// 重新调度后本次不再扫描到
+	timer.tick()
+	assertQueue(t, s, "mail", "a")
+}
+
+func TestSchedulingLatency(t *testing.T) {
+	s := newFakeRedis(t)
+	now := time.Now().Unix()
+	s.addJob("late", "mail", now-30)
+	// 外部写入的小数分数不影响扫描
+	s.do("ZADD", KEY_JOB_POOL, strconv.FormatInt(now-5, 10)+".5", "fractional")
+	s.do("HSET", PREFIX_JOB_BUCKET+"fractional", FIELD_TOPIC, "mail")
+	timer := newTestTimer(t, s, nil)
+	var mutex sync.Mutex
+	var lags []time.Duration
+	timer.OnSchedulingLatency = func(topic string, lag time.Duration) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		lags = append(lags, lag)
+	}
+	timer.tick()
+	assertQueue(t, s, "mail", "late", "fractional")
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(lags) != 2 {
+		t.Fatalf("expected 2 latency reports, got %d", len(lags))
+	}
+	for _, lag := range lags {
+		if lag < 5*time.Second {
+			t.Errorf("expected a positive lag of at least 5s, got %s", lag)
+		}
+	}
+	if lags[0] < 30*time.Second && lags[1] < 30*time.Second {
+		t.Errorf("expected the late job to report at least 30s, got %v", lags)
+	}
+}
+
+func TestOrphanCallback(t *testing.T) {
+	s := newFakeRedis(t)
+	now := time.Now().Unix()
+	s.addJob("a", "mail", now-1)
+	s.do("ZADD", KEY_JOB_POOL, strconv.FormatInt(now-1, 10), "orphan")
+	timer := newTestTimer(t, s, nil)
+	var orphans []string
+	timer.OnOrphan = func(jobID string) {
+		orphans = append(orphans, jobID)
+	}
+	timer.tick()
+	if !reflect.DeepEqual(orphans, []string{"orphan"}) {
+		t.Fatalf("expected orphan callback for [orphan], got %v", orphans)
+	}
+	if _, ok := s.score(KEY_JOB_POOL, "orphan"); ok {
+		t.Fatal("orphan is still in the pool")
+	}
+	assertQueue(t, s, "mail", "a")
+}
+
+func TestMoveGroupedTopics(t *testing.T) {
+	s := newFakeRedis(t)
+	now := time.Now().Unix()
+	topics := []string{"t1", "t2", "t3", "t4", "t5"}
+	for _, topic := range topics {
+		s.addJob(topic+"-a", topic, now-2)
+		s.addJob(topic+"-b", topic, now-1)
+	}
+	timer := newTestTimer(t, s, func(config *utils.Config) {
+		config.Delayer.MoveGroupSize = 2
+		config.Delayer.Notify = true
+	})
+	// Bucket变更使各Topic的回复数不同, 校验分组接收的回复下标
+	timer.BeforeReady = func(job *Job) error {
+		if job.Topic == "t2" || job.Topic == "t4" {
+			job.Fields["stamp"] = "1"
+		}
+		return nil
+	}
+	var mutex sync.Mutex
+	ready := make(map[string]int)
+	timer.OnJobReady = func(topic string, ids []string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		ready[topic] += len(ids)
+	}
+	timer.tick()
+	for _, topic := range topics {
+		assertQueue(t, s, topic, topic+"-a", topic+"-b")
+		if ready[topic] != 2 {
+			t.Errorf("topic %s: expected 2 ready jobs, got %d", topic, ready[topic])
+		}
+	}
+	if v := s.field("t2-a", "stamp"); v != "1" {
+		t.Errorf("expected bucket change for grouped topic, got %q", v)
+	}
+	if n := len(s.published(PREFIX_NOTIFY_CHANNEL + "t3")); n != 1 {
+		t.Errorf("expected 1 notification for t3, got %d", n)
+	}
+}
+
+func BenchmarkMoveGroupedTopics(b *testing.B) {
+	for _, groupSize := range []int{0, 8} {
+		groupSize := groupSize
+		b.Run(fmt.Sprintf("group=%d", groupSize), func(b *testing.B) {
+			s := newFakeRedis(b)
+			timer := newTestTimer(b, s, func(config *utils.Config) {
+				config.Delayer.MoveGroupSize = groupSize
+			})
+			now := time.Now().Unix()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				for j := 0; j < 32; j++ {
+					s.addJob(fmt.Sprintf("%d-%d", i, j), fmt.Sprintf("topic-%d", j), now-1)
+				}
+				b.StartTimer()
+				timer.tick()
+			}
+		})
+	}
+}
+
+// 等待条件成立, 超时后失败
+func waitFor(t testing.TB, timeout time.Duration, message string, fn func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !fn() {
+		if time.Now().After(deadline) {
+			t.Fatal(message)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRestartAppliesConfig(t *testing.T) {
+	s := newFakeRedis(t)
+	timer := newTestTimer(t, s, func(config *utils.Config) {
+		config.Delayer.TimerInterval = 60000
+	})
+	timer.Start()
+	s.addJob("a", "mail", time.Now().Unix()-1)
+	// 无效配置不影响运行中的定时器
+	invalid := timer.Config
+	invalid.Delayer.TimerInterval = 0
+	if err := timer.Restart(invalid); err == nil {
+		t.Fatal("expected an error for an invalid config")
+	}
+	if timer.Ticker == nil {
+		t.Fatal("timer stopped after a failed restart")
+	}
+	config := timer.Config
+	config.Delayer.TimerInterval = 20
+	if err := timer.Restart(config); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, time.Second, "job was not moved with the new interval", func() bool {
+		return len(s.queue("mail")) == 1
+	})
+	assertQueue(t, s, "mail", "a")
+}
+
+func TestTickOverrun(t *testing.T) {
+	s := newFakeRedis(t)
+	timer := newTestTimer(t, s, func(config *utils.Config) {
+		config.Delayer.TimerInterval = 20
+	})
+	s.setHook(func(cmd string, args []string) error {
+		if cmd == "ZRANGEBYSCORE" && args[0] == KEY_JOB_POOL {
+			time.Sleep(50 * time.Millisecond)
+		}
+		return nil
+	})
+	var elapsed time.Duration
+	timer.OnTickOverrun = func(d time.Duration) {
+		elapsed = d
+	}
+	timer.tick()
+	if elapsed < 50*time.Millisecond {
+		t.Fatalf("expected an overrun of at least 50ms, got %s", elapsed)
+	}
+	if !timer.Logger.(*testLogger).contains("Timer overrun") {
+		t.Fatal("expected an overrun warning")
+	}
+	// 未超过间隔时不回调
+	s.setHook(nil)
+	elapsed = 0
+	timer.Config.Delayer.TimerInterval = 1000
+	timer.tick()
+	if elapsed != 0 {
+		t.Fatalf("unexpected overrun %s", elapsed)
+	}
+}
+
+func TestTickBudgetStopsSlowMoves(t *testing.T) {
+	s := newFakeRedis(t)
+	now := time.Now().Unix()
+	total := 5 * TICK_BUDGET_CHUNK
+	for i := 0; i < total; i++ {
+		s.addJob(fmt.Sprintf("job-%03d", i), "mail", now-1)
+	}
+	s.setHook(func(cmd string, args []string) error {
+		if cmd == "LPUSH" {
+			time.Sleep(60 * time.Millisecond)
+		}
+		return nil
+	})
+	timer := newTestTimer(t, s, func(config *utils.Config) {
+		config.Delayer.TickBudget = 100
+	})
+	start := time.Now()
+	timer.tick()
+	elapsed := time.Since(start)
+	moved := len(s.queue("mail"))
+	if moved == 0 || moved >= total {
+		t.Fatalf("expected a partial move, moved %d of %d", moved, total)
+	}
+	if elapsed > 400*time.Millisecond {
+		t.Fatalf("expected the tick to stop near the budget, took %s", elapsed)
+	}
+	// 剩余任务留在JobPool中, 下次执行继续移动
+	assertPending(t, s, fmt.Sprintf("job-%03d", total-1))
+}
+
+func TestHugeDueSetCapped(t *testing.T) {
+	if testing.Short() {
+		t.Skip("prepares more than MAX_BATCH_SIZE jobs")
+	}
+	s := newFakeRedis(t)
+	total := MAX_BATCH_SIZE + 50
+	fireAt := float64(time.Now().Unix() - 1)
+	// 直接写入, 避免逐条通过连接准备数据
+	for i := 0; i < total; i++ {
+		jobID := "job" + strconv.Itoa(i)
+		s.ZAdd(KEY_JOB_POOL, fireAt, jobID)
+		s.HSet(PREFIX_JOB_BUCKET+jobID, FIELD_TOPIC, "mail")
+	}
+	timer := newTestTimer(t, s, func(config *utils.Config) {
+		// 配置错误的超大批次
+		config.Delayer.BatchSize = 1 << 30
+	})
+	timer.tick()
+	if n := len(s.queue("mail")); n != MAX_BATCH_SIZE {
+		t.Fatalf("expected a single tick to move at most %d jobs, got %d", MAX_BATCH_SIZE, n)
+	}
+	// 查询Topic前已截断
+	if n := s.count("HGET"); n > MAX_BATCH_SIZE {
+		t.Fatalf("expected at most %d topic lookups, got %d", MAX_BATCH_SIZE, n)
+	}
+	if n, _ := s.do("ZCARD", KEY_JOB_POOL).(int64); n != 50 {
+		t.Fatalf("expected 50 jobs left for the next tick, got %d", n)
+	}
+}
+
+func TestDisabledTimerNeverMoves(t *testing.T) {
+	s := newFakeRedis(t)
+	s.addJob("a", "mail", time.Now().Unix()-1)
+	timer := newTestTimer(t, s, func(config *utils.Config) {
+		config.Delayer.Disabled = true
+		config.Delayer.TimerInterval = 10
+	})
+	timer.Start()
+	defer timer.Stop()
+	time.Sleep(100 * time.Millisecond)
+	assertQueue(t, s, "mail")
+	assertPending(t, s, "a")
+	if n := s.count("ZRANGEBYSCORE") + s.count("EVALSHA") + s.count("EVAL"); n != 0 {
+		t.Fatalf("expected a disabled timer not to scan the pool, got %d scans", n)
+	}
+	if !timer.Logger.(*testLogger).contains("Timer is disabled") {
+		t.Fatal("expected the disabled timer to log at start")
+	}
+}
+
+func TestOnTopicReady(t *testing.T) {
+	s := newFakeRedis(t)
+	now := time.Now().Unix()
+	s.addJob("a", "mail", now-1)
+	s.addJob("b", "sms", now-1)
+	s.addJob("c", "push", now-1)
+	s.addJob("d", "failed", now-1)
+	s.setHook(func(cmd string, args []string) error {
+		if cmd == "LPUSH" && args[0] == PREFIX_READY_QUEUE+"failed" {
+			return fakeError("ERR injected")
+		}
+		return nil
+	})
+	timer := newTestTimer(t, s, nil)
+	var mutex sync.Mutex
+	calls := make(map[string][]string)
+	record := func(name string) func(ids []string) {
+		return func(ids []string) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			calls[name] = append(calls[name], ids...)
+		}
+	}
+	timer.OnTopicReady("mail", record("mail handler"))
+	timer.OnTopicReady("sms", record("sms handler"))
+	timer.OnTopicReady("failed", record("failed handler"))
+	timer.OnJobReady = func(topic string, ids []string) {
+		record("global " + topic)(ids)
+	}
+	timer.tick()
+	// 未注册的Topic使用全局回调, 移动失败时不回调
+	expected := map[string][]string{
+		"mail handler": {"a"},
+		"sms handler":  {"b"},
+		"global push":  {"c"},
+	}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Fatalf("expected callbacks %v, got %v", expected, calls)
+	}
+}
+
+// 包含 substr 的日志条数
+func (p *testLogger) count(substr string) int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	n := 0
+	for _, message := range p.messages {
+		if strings.Contains(message, substr) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestReadyLogSampling(t *testing.T) {
+	for _, c := range []struct {
+		sample   int
+		expected int
+	}{
+		{0, 100},
+		{1, 100},
+		{10, 10},
+	} {
+		s := newFakeRedis(t)
+		now := time.Now().Unix()
+		for i := 0; i < 100; i++ {
+			s.addJob("job"+strconv.Itoa(i), "topic"+strconv.Itoa(i), now-1)
+		}
+		s.addJob("bad", "failed", now-1)
+		s.setHook(func(cmd string, args []string) error {
+			if cmd == "LPUSH" && args[0] == PREFIX_READY_QUEUE+"failed" {
+				return fakeError("ERR injected")
+			}
+			return nil
+		})
+		timer := newTestTimer(t, s, func(config *utils.Config) {
+			config.Delayer.ReadyLogSampleN = c.sample
+		})
+		timer.tick()
+		logger := timer.Logger.(*testLogger)
+		if n := logger.count("Job is ready"); n != c.expected {
+			t.Errorf("sample %d: expected %d ready logs for 100 moves, got %d", c.sample, c.expected, n)
+		}
+		// 错误不受采样影响
+		if !logger.contains("ERR injected") {
+			t.Errorf("sample %d: expected the move error to be logged", c.sample)
+		}
+	}
+}
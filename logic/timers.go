@@ -0,0 +1,128 @@
+package logic
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// 定时器运行统计
+type TimerStats struct {
+	// 已执行次数
+	Ticks uint64
+	// 已移动至ReadyQueue的任务数
+	Moved uint64
+}
+
+// 获取运行统计
+func (p *Timer) Stats() TimerStats {
+	return TimerStats{
+		Ticks: atomic.LoadUint64(&p.tickCount),
+		Moved: atomic.LoadUint64(&p.movedCount),
+	}
+}
+
+// 定时器组, 在同一进程中管理多个独立的定时器, 如不同命名空间
+type Timers struct {
+	mutex  sync.RWMutex
+	timers map[string]*Timer
+}
+
+// 创建实例
+func NewTimers() *Timers {
+	return &Timers{
+		timers: make(map[string]*Timer),
+	}
+}
+
+// 添加定时器, 需已调用 Init
+func (p *Timers) Add(name string, timer *Timer) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if _, ok := p.timers[name]; ok {
+		return fmt.Errorf("timer %s already exists", name)
+	}
+	p.timers[name] = timer
+	return nil
+}
+
+// 获取定时器
+func (p *Timers) Get(name string) (*Timer, bool) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	timer, ok := p.timers[name]
+	return timer, ok
+}
+
+// 定时器名称
+func (p *Timers) Names() []string {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	names := make([]string, 0, len(p.timers))
+	for name := range p.timers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// 启动全部定时器
+func (p *Timers) Start() {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	for _, timer := range p.timers {
+		timer.Start()
+	}
+}
+
+// 并行停止全部定时器, 返回第一个错误
+func (p *Timers) StopContext(ctx context.Context) error {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	var wg sync.WaitGroup
+	errs := make(chan error, len(p.timers))
+	for _, timer := range p.timers {
+		wg.Add(1)
+		go func(timer *Timer) {
+			defer wg.Done()
+			if err := timer.StopContext(ctx); err != nil {
+				errs <- err
+			}
+		}(timer)
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+// 停止全部定时器
+func (p *Timers) Stop() {
+	p.StopContext(context.Background())
+}
+
+// 停止全部定时器并关闭连接池, 返回第一个错误
+func (p *Timers) Close() error {
+	p.Stop()
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	var first error
+	for _, timer := range p.timers {
+		if err := timer.pool().Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// 各定时器的运行统计
+func (p *Timers) Stats() map[string]TimerStats {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	stats := make(map[string]TimerStats, len(p.timers))
+	for name, timer := range p.timers {
+		stats[name] = timer.Stats()
+	}
+	return stats
+}
@@ -0,0 +1,57 @@
+package logic
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dcsunny/delayer/utils"
+)
+
+func TestTimersAcrossNamespaces(t *testing.T) {
+	s := newFakeRedis(t)
+	now := time.Now().Unix()
+	timers := NewTimers()
+	for _, namespace := range []string{"first", "second"} {
+		namespace := namespace
+		addJobIn(s, 0, NewKeys(namespace), namespace+"-job", "mail", now-1)
+		timer := newTestTimer(t, s, func(config *utils.Config) {
+			config.Delayer.Namespace = namespace
+			config.Delayer.TimerInterval = 20
+		})
+		if err := timers.Add(namespace, timer); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := timers.Add("first", &Timer{}); err == nil {
+		t.Fatal("expected an error for a duplicate timer name")
+	}
+	if names := timers.Names(); !reflect.DeepEqual(names, []string{"first", "second"}) {
+		t.Fatalf("unexpected timer names %v", names)
+	}
+	timers.Start()
+	waitFor(t, time.Second, "timers did not move their jobs", func() bool {
+		stats := timers.Stats()
+		return stats["first"].Moved == 1 && stats["second"].Moved == 1
+	})
+	for _, namespace := range []string{"first", "second"} {
+		if ids := queueIn(s, 0, NewKeys(namespace), "mail"); !reflect.DeepEqual(ids, []string{namespace + "-job"}) {
+			t.Fatalf("%s: expected only its own job moved, got %v", namespace, ids)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := timers.StopContext(ctx); err != nil {
+		t.Fatalf("expected both timers to stop cleanly, got %v", err)
+	}
+	// 停止后不再执行
+	before := timers.Stats()
+	time.Sleep(60 * time.Millisecond)
+	if after := timers.Stats(); !reflect.DeepEqual(after, before) {
+		t.Fatalf("expected no ticks after stop, got %v then %v", before, after)
+	}
+	if err := timers.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
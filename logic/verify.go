@@ -0,0 +1,210 @@
+package logic
+
+import (
+	"strings"
+	"time"
+
+	"github.com/dcsunny/delayer/utils"
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	// 修复时重新调度无JobPool记录的Bucket的延迟
+	REPAIR_DELAY = time.Minute
+	// Bucket在该时长内有活动时不视为孤立, 刚被客户端取出的任务已不在ReadyQueue中但Bucket仍存在
+	REPAIR_GRACE = 10 * time.Minute
+)
+
+// 一致性检查报告
+type Report struct {
+	// JobPool中存在但没有Bucket的任务
+	PoolOrphans []string
+	// Bucket存在但不在JobPool、RetryPool、隔离集合、暂停暂存、ReadyQueue与死信队列中, 且超过 REPAIR_GRACE 无活动的任务
+	BucketOrphans []string
+}
+
+// 检查JobPool与Bucket的一致性
+func (p *Admin) Verify() (Report, error) {
+	conn := p.Pool.Get()
+	defer conn.Close()
+	keys := p.keys()
+	report := Report{}
+	// JobPool中没有Bucket的任务
+	cursor := "0"
+	for {
+		values, err := redis.Values(conn.Do("ZSCAN", keys.JobPool, cursor, "COUNT", SCAN_BATCH_SIZE))
+		if err != nil {
+			return report, err
+		}
+		cursor, _ = redis.String(values[0], nil)
+		scores, _ := scoreMap(values[1], nil)
+		for jobID := range scores {
+			exists, err := redis.Bool(conn.Do("EXISTS", keys.JobBucket+jobID))
+			if err != nil {
+				return report, err
+			}
+			if !exists {
+				report.PoolOrphans = append(report.PoolOrphans, jobID)
+			}
+		}
+		if cursor == "0" {
+			break
+		}
+	}
+	// 已就绪、已进入死信队列或暂停中的任务
+	queued, err := p.queuedJobIDs(conn, keys.ReadyQueue, keys.DeadQueue, keys.HeldPool)
+	if err != nil {
+		return report, err
+	}
+	// 没有JobPool记录的Bucket
+	cursor = "0"
+	for {
+		values, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", keys.JobBucket+"*", "COUNT", SCAN_BATCH_SIZE))
+		if err != nil {
+			return report, err
+		}
+		cursor, _ = redis.String(values[0], nil)
+		buckets, _ := redis.Strings(values[1], nil)
+		for _, bucket := range buckets {
+			jobID := strings.TrimPrefix(bucket, keys.JobBucket)
+			if queued[jobID] {
+				continue
+			}
+			orphan, err := p.bucketOrphan(conn, jobID)
+			if err != nil {
+				return report, err
+			}
+			if orphan {
+				report.BucketOrphans = append(report.BucketOrphans, jobID)
+			}
+		}
+		if cursor == "0" {
+			return report, nil
+		}
+	}
+}
+
+// 修复不一致: 移除没有Bucket的JobPool记录, 重新调度没有JobPool记录的Bucket
+func (p *Admin) Repair() (Report, error) {
+	report, err := p.Verify()
+	if err != nil {
+		return report, err
+	}
+	conn := p.Pool.Get()
+	defer conn.Close()
+	keys := p.keys()
+	for _, jobID := range report.PoolOrphans {
+		if _, err := conn.Do("ZREM", keys.JobPool, jobID); err != nil {
+			return report, err
+		}
+	}
+	score := time.Now().Add(REPAIR_DELAY).Unix()
+	var repaired []string
+	for _, jobID := range report.BucketOrphans {
+		// 写入前重新检查, 检查期间可能已被重新调度或取出
+		orphan, err := p.bucketOrphan(conn, jobID)
+		if err != nil {
+			return report, err
+		}
+		if !orphan {
+			continue
+		}
+		if _, err := conn.Do("ZADD", keys.JobPool, "NX", score, jobID); err != nil {
+			return report, err
+		}
+		repaired = append(repaired, jobID)
+	}
+	report.BucketOrphans = repaired
+	return report, nil
+}
+
+// Bucket是否孤立: 存在, 不在各有序集合中, 且超过 REPAIR_GRACE 无活动
+func (p *Admin) bucketOrphan(conn redis.Conn, jobID string) (bool, error) {
+	keys := p.keys()
+	exists, err := redis.Bool(conn.Do("EXISTS", keys.JobBucket+jobID))
+	if err != nil || !exists {
+		return false, err
+	}
+	pending, err := p.inPool(conn, jobID, keys.JobPool, keys.RetryPool, keys.Quarantine)
+	if err != nil || pending {
+		return false, err
+	}
+	active, err := p.recentlyActive(conn, jobID)
+	return !active, err
+}
+
+// Bucket最近是否有活动, 优先按空闲时间判断, 不支持 OBJECT IDLETIME 时按 ready_at 判断
+func (p *Admin) recentlyActive(conn redis.Conn, jobID string) (bool, error) {
+	key := p.keys().JobBucket + jobID
+	// OBJECT IDLETIME 不更新空闲时间, 需在读取字段前执行
+	idle, err := redis.Int64(conn.Do("OBJECT", "IDLETIME", key))
+	if err == nil {
+		return time.Duration(idle)*time.Second < REPAIR_GRACE, nil
+	}
+	if err == redis.ErrNil {
+		return true, nil
+	}
+	// 命令被禁用或使用 LFU 淘汰策略时返回错误回复
+	if _, ok := err.(redis.Error); !ok {
+		return false, err
+	}
+	value, err := redis.String(conn.Do("HGET", key, FIELD_READY_AT))
+	if err == redis.ErrNil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	readyAt, err := utils.StringToInt64(value)
+	if err != nil {
+		return false, nil
+	}
+	return time.Since(time.Unix(readyAt, 0)) < REPAIR_GRACE, nil
+}
+
+// 获取队列中的全部任务ID
+func (p *Admin) queuedJobIDs(conn redis.Conn, prefixes ...string) (map[string]bool, error) {
+	jobIDs := make(map[string]bool)
+	for _, prefix := range prefixes {
+		cursor := "0"
+		for {
+			values, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", prefix+"*", "COUNT", SCAN_BATCH_SIZE))
+			if err != nil {
+				return nil, err
+			}
+			cursor, _ = redis.String(values[0], nil)
+			queues, _ := redis.Strings(values[1], nil)
+			for _, queue := range queues {
+				command := "LRANGE"
+				if prefix == p.keys().HeldPool {
+					command = "ZRANGE"
+				}
+				ids, err := redis.Strings(conn.Do(command, queue, 0, -1))
+				if err != nil {
+					return nil, err
+				}
+				for _, id := range ids {
+					jobIDs[id] = true
+				}
+			}
+			if cursor == "0" {
+				break
+			}
+		}
+	}
+	return jobIDs, nil
+}
+
+// 任务是否在任一有序集合中
+func (p *Admin) inPool(conn redis.Conn, jobID string, pools ...string) (bool, error) {
+	for _, pool := range pools {
+		_, err := scoreInt64(conn.Do("ZSCORE", pool, jobID))
+		if err == nil {
+			return true, nil
+		}
+		if err != redis.ErrNil {
+			return false, err
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,81 @@
+package logic
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVerifyAndRepair(t *testing.T) {
+	s := newFakeRedis(t)
+	now := time.Now().Unix()
+	s.addJob("ok", "mail", now+60)
+	// JobPool中没有Bucket
+	s.do("ZADD", KEY_JOB_POOL, strconv.FormatInt(now+60, 10), "pool-orphan")
+	// Bucket没有JobPool记录且长时间无活动
+	s.do("HSET", PREFIX_JOB_BUCKET+"bucket-orphan", FIELD_TOPIC, "mail")
+	s.setIdle(PREFIX_JOB_BUCKET+"bucket-orphan", 2*REPAIR_GRACE)
+	// 已在ReadyQueue中
+	s.do("HSET", PREFIX_JOB_BUCKET+"queued", FIELD_TOPIC, "mail")
+	s.do("LPUSH", PREFIX_READY_QUEUE+"mail", "queued")
+	s.setIdle(PREFIX_JOB_BUCKET+"queued", 2*REPAIR_GRACE)
+	// 刚被客户端取出, 仍在处理中
+	s.do("HSET", PREFIX_JOB_BUCKET+"popped", FIELD_TOPIC, "mail")
+	admin := newTestAdmin(t, s)
+	report, err := admin.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(report.PoolOrphans, []string{"pool-orphan"}) {
+		t.Errorf("pool orphans: expected [pool-orphan], got %v", report.PoolOrphans)
+	}
+	if !reflect.DeepEqual(report.BucketOrphans, []string{"bucket-orphan"}) {
+		t.Errorf("bucket orphans: expected [bucket-orphan], got %v", report.BucketOrphans)
+	}
+	report, err = admin.Repair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(report.BucketOrphans, []string{"bucket-orphan"}) {
+		t.Errorf("repaired: expected [bucket-orphan], got %v", report.BucketOrphans)
+	}
+	if _, ok := s.score(KEY_JOB_POOL, "pool-orphan"); ok {
+		t.Error("pool orphan was not removed")
+	}
+	score, ok := s.score(KEY_JOB_POOL, "bucket-orphan")
+	if !ok {
+		t.Fatal("bucket orphan was not rescheduled")
+	}
+	if int64(score) < now+int64(REPAIR_DELAY/time.Second) {
+		t.Errorf("expected the orphan to be rescheduled after %s, got %d", REPAIR_DELAY, int64(score))
+	}
+	if _, ok := s.score(KEY_JOB_POOL, "popped"); ok {
+		t.Error("recently popped job was rescheduled")
+	}
+	report, err = admin.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.PoolOrphans) != 0 || len(report.BucketOrphans) != 0 {
+		t.Fatalf("expected a clean report after repair, got %+v", report)
+	}
+}
+
+func TestRepairUsesReadyAtWithoutIdleTime(t *testing.T) {
+	s := newFakeRedis(t)
+	s.update(func() {
+		s.noObject = true
+	})
+	now := time.Now()
+	s.do("HSET", PREFIX_JOB_BUCKET+"old", FIELD_TOPIC, "mail", FIELD_READY_AT, strconv.FormatInt(now.Add(-2*REPAIR_GRACE).Unix(), 10))
+	s.do("HSET", PREFIX_JOB_BUCKET+"recent", FIELD_TOPIC, "mail", FIELD_READY_AT, strconv.FormatInt(now.Unix(), 10))
+	admin := newTestAdmin(t, s)
+	report, err := admin.Repair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(report.BucketOrphans, []string{"old"}) {
+		t.Fatalf("expected only the old bucket to be repaired, got %v", report.BucketOrphans)
+	}
+}
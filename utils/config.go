@@ -1,12 +1,19 @@
 package utils
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"strings"
 
 	"gopkg.in/ini.v1"
 )
 
+const (
+	READY_ORDER_FIFO = "fifo"
+	READY_ORDER_LIFO = "lifo"
+)
+
 // 配置数据
 type Config struct {
 	Delayer Delayer
@@ -20,18 +27,75 @@ type Delayer struct {
 	BucketMaxLifetime int64
 	AccessLog         string
 	ErrorLog          string
+	LogLevel          string
+	BatchSize         int
+	FairScheduling    bool
+	TopicWeights      map[string]int
+	TopicCacheSize    int
+	MoveGroupSize     int
+	ReadyOrder        string
+	Notify            bool
+	Disabled          bool // 对应配置 enabled = false, 零值时正常移动任务
+	GroupField        string
+	Namespace         string
+	// 命名空间绑定的数据库编号, 为 nil 时使用 Redis.Database
+	NamespaceDatabase *int
+	CatchUpThreshold  int
+	CatchUpBatchSize  int
+	CatchUpPause      int64
+	ReadyLogSampleN   int
+	RetryDelay        int64
+	MoveRetries       int
+	MoveRetryWait     int64
+	TickBudget        int64
+	ServerTime        bool
+	MaxTopics         int
+	EmptyTopic        string
+	StartupDelay      int64
+	QueueAgeThreshold int64
 }
 
 // redis 节点数据
 type Redis struct {
-	Host            string
-	Port            string
-	Database        int
+	Host     string
+	Port     string
+	Database int
+	// 是否显式配置了 Database, 为 false 且 Database 为 0 时不执行 SELECT
+	DatabaseSet     bool
 	Password        string
+	PasswordFile    string
 	MaxIdle         int
 	MaxActive       int
 	IdleTimeout     int64
 	ConnMaxLifetime int64
+	Cluster         bool
+	DialTimeout     int64
+	ReadTimeout     int64
+	WriteTimeout    int64
+	Compat          bool
+	// 写入返回 READONLY/MASTERDOWN 时重建连接
+	Redial bool
+	// 集群模式下键分布在不同槽位, 不使用事务分步移动
+	CrossSlot bool
+}
+
+// 校验配置
+func (p Config) Validate() error {
+	if p.Delayer.TimerInterval <= 0 {
+		return errors.New("invalid config: timer_interval must be greater than 0")
+	}
+	if p.Redis.Host == "" || p.Redis.Port == "" {
+		return errors.New("invalid config: redis host and port are required")
+	}
+	if p.Redis.MaxActive > 0 && p.Redis.MaxIdle > p.Redis.MaxActive {
+		return errors.New("invalid config: max_idle cannot be greater than max_active")
+	}
+	switch p.Delayer.ReadyOrder {
+	case "", READY_ORDER_FIFO, READY_ORDER_LIFO:
+	default:
+		return fmt.Errorf("invalid config: unknown ready_order %s", p.Delayer.ReadyOrder)
+	}
+	return nil
 }
 
 // 载入配置
@@ -51,33 +115,121 @@ func LoadConfig(fileName string) Config {
 	timerInterval, _ := delayer.Key("timer_interval").Int64()
 	accessLog := delayer.Key("access_log").String()
 	errorLog := delayer.Key("error_log").String()
+	logLevel := delayer.Key("log_level").MustString("info")
+	batchSize, _ := delayer.Key("batch_size").Int()
+	fairScheduling, _ := delayer.Key("fair_scheduling").Bool()
+	topicWeights := parseWeights(delayer.Key("topic_weights").String())
+	topicCacheSize, _ := delayer.Key("topic_cache_size").Int()
+	moveGroupSize, _ := delayer.Key("move_group_size").Int()
+	notify, _ := delayer.Key("notify").Bool()
+	enabled := delayer.Key("enabled").MustBool(true)
+	groupField := delayer.Key("group_field").MustString("topic")
+	namespace := delayer.Key("namespace").String()
+	var namespaceDatabase *int
+	if db, err := delayer.Key("namespace_database").Int(); err == nil {
+		namespaceDatabase = &db
+	}
+	catchUpThreshold, _ := delayer.Key("catch_up_threshold").Int()
+	catchUpBatchSize := delayer.Key("catch_up_batch_size").MustInt(1000)
+	catchUpPause := delayer.Key("catch_up_pause").MustInt64(100)
+	readyLogSampleN, _ := delayer.Key("ready_log_sample_n").Int()
+	retryDelay, _ := delayer.Key("retry_delay").Int64()
+	moveRetries, _ := delayer.Key("move_retries").Int()
+	moveRetryWait := delayer.Key("move_retry_wait").MustInt64(10)
+	tickBudget, _ := delayer.Key("tick_budget").Int64()
+	serverTime, _ := delayer.Key("server_time").Bool()
+	maxTopics, _ := delayer.Key("max_topics").Int()
+	emptyTopic := delayer.Key("empty_topic").String()
+	startupDelay, _ := delayer.Key("startup_delay").Int64()
+	queueAgeThreshold, _ := delayer.Key("queue_age_threshold").Int64()
+	readyOrder := delayer.Key("ready_order").In(READY_ORDER_FIFO, []string{READY_ORDER_FIFO, READY_ORDER_LIFO})
 	redis := conf.Section("redis")
 	host := redis.Key("host").String()
 	port := redis.Key("port").String()
-	database, _ := redis.Key("database").Int()
+	database, err := redis.Key("database").Int()
+	databaseSet := err == nil
 	password := redis.Key("password").String()
+	passwordFile := redis.Key("password_file").String()
 	maxIdle, _ := redis.Key("max_idle").Int()
 	maxActive, _ := redis.Key("max_active").Int()
 	idleTimeout, _ := redis.Key("idle_timeout").Int64()
 	connMaxLifetime, _ := redis.Key("conn_max_lifetime").Int64()
+	cluster, _ := redis.Key("cluster").Bool()
+	dialTimeout := redis.Key("dial_timeout").MustInt64(5)
+	readTimeout := redis.Key("read_timeout").MustInt64(5)
+	writeTimeout := redis.Key("write_timeout").MustInt64(5)
+	compat, _ := redis.Key("compat").Bool()
+	redial := redis.Key("redial").MustBool(true)
+	crossSlot, _ := redis.Key("cross_slot").Bool()
 	// 返回
 	data := Config{
 		Delayer: Delayer{
-			Pid:           pid,
-			TimerInterval: timerInterval,
-			AccessLog:     accessLog,
-			ErrorLog:      errorLog,
+			Pid:               pid,
+			TimerInterval:     timerInterval,
+			AccessLog:         accessLog,
+			ErrorLog:          errorLog,
+			LogLevel:          logLevel,
+			BatchSize:         batchSize,
+			FairScheduling:    fairScheduling,
+			TopicWeights:      topicWeights,
+			TopicCacheSize:    topicCacheSize,
+			MoveGroupSize:     moveGroupSize,
+			ReadyOrder:        readyOrder,
+			Notify:            notify,
+			Disabled:          !enabled,
+			GroupField:        groupField,
+			Namespace:         namespace,
+			NamespaceDatabase: namespaceDatabase,
+			CatchUpThreshold:  catchUpThreshold,
+			CatchUpBatchSize:  catchUpBatchSize,
+			CatchUpPause:      catchUpPause,
+			ReadyLogSampleN:   readyLogSampleN,
+			RetryDelay:        retryDelay,
+			MoveRetries:       moveRetries,
+			MoveRetryWait:     moveRetryWait,
+			TickBudget:        tickBudget,
+			ServerTime:        serverTime,
+			MaxTopics:         maxTopics,
+			EmptyTopic:        emptyTopic,
+			StartupDelay:      startupDelay,
+			QueueAgeThreshold: queueAgeThreshold,
 		},
 		Redis: Redis{
 			Host:            host,
 			Port:            port,
 			Database:        database,
+			DatabaseSet:     databaseSet,
 			Password:        password,
+			PasswordFile:    passwordFile,
 			MaxIdle:         maxIdle,
 			MaxActive:       maxActive,
 			IdleTimeout:     idleTimeout,
 			ConnMaxLifetime: connMaxLifetime,
+			Cluster:         cluster,
+			DialTimeout:     dialTimeout,
+			ReadTimeout:     readTimeout,
+			WriteTimeout:    writeTimeout,
+			Compat:          compat,
+			Redial:          redial,
+			CrossSlot:       crossSlot,
 		},
 	}
 	return data
 }
+
+// 解析权重配置, 格式: topic1:3,topic2:1
+func parseWeights(value string) map[string]int {
+	weights := make(map[string]int)
+	for _, item := range strings.Split(value, ",") {
+		kv := strings.SplitN(strings.TrimSpace(item), ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		weight, err := StringToInt(strings.TrimSpace(kv[1]))
+		if err != nil {
+			continue
+		}
+		weights[strings.TrimSpace(kv[0])] = weight
+	}
+	return weights
+}
@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// 写入配置文件, 返回文件名
+func writeConfig(t *testing.T, content string) string {
+	fileName := filepath.Join(t.TempDir(), "delayer.conf")
+	if err := ioutil.WriteFile(fileName, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+func TestLoadConfigDatabaseSet(t *testing.T) {
+	for _, c := range []struct {
+		redis    string
+		database int
+		set      bool
+	}{
+		{"host = 127.0.0.1\n", 0, false},
+		{"host = 127.0.0.1\ndatabase = 0\n", 0, true},
+		{"host = 127.0.0.1\ndatabase = 2\n", 2, true},
+	} {
+		config := LoadConfig(writeConfig(t, "[delayer]\ntimer_interval = 1000\n[redis]\n"+c.redis))
+		if config.Redis.Database != c.database || config.Redis.DatabaseSet != c.set {
+			t.Errorf("%q: expected database %d (set: %v), got %d (set: %v)", c.redis, c.database, c.set, config.Redis.Database, config.Redis.DatabaseSet)
+		}
+	}
+}
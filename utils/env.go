@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// 从环境变量构建配置, 未设置的变量使用默认值:
+// DELAYER_PID             单例PID文件, 默认空
+// DELAYER_INTERVAL        计算间隔时间(毫秒), 默认 1000
+// DELAYER_ACCESS_LOG      存取日志, 默认空 (仅输出到标准输出)
+// DELAYER_ERROR_LOG       错误日志, 默认空 (仅输出到标准输出)
+// DELAYER_LOG_LEVEL       日志级别, 默认 info
+// DELAYER_BATCH_SIZE      每次最多移动的任务数, 默认 0
+// DELAYER_ENABLED         是否启用, 默认 true
+// DELAYER_NAMESPACE       命名空间, 默认空
+// DELAYER_READY_ORDER     ReadyQueue顺序, 默认 fifo
+// DELAYER_GROUP_FIELD     分组字段, 默认 topic
+// REDIS_HOST              连接地址, 默认 127.0.0.1
+// REDIS_PORT              连接端口, 默认 6379
+// REDIS_DB                数据库编号, 默认 0
+// REDIS_PASSWORD          密码, 默认空
+// REDIS_PASSWORD_FILE     密码文件, 默认空
+// REDIS_MAX_IDLE          最大空闲连接数, 默认 2
+// REDIS_MAX_ACTIVE        最大激活连接数, 默认 20
+// REDIS_IDLE_TIMEOUT      空闲连接超时时间(秒), 默认 3600
+// REDIS_CONN_MAX_LIFETIME 连接最大生存时间(秒), 默认 3600
+// REDIS_DIAL_TIMEOUT      连接超时时间(秒), 默认 5
+// REDIS_READ_TIMEOUT      读超时时间(秒), 默认 5
+// REDIS_WRITE_TIMEOUT     写超时时间(秒), 默认 5
+// REDIS_CLUSTER           集群模式, 默认 false
+// REDIS_COMPAT            兼容模式, 默认 false
+// REDIS_REDIAL            主从切换后重建连接, 默认 true
+// REDIS_CROSS_SLOT        不使用事务分步移动, 默认 false
+// 配置无效时返回错误, 见 Config.Validate
+func ConfigFromEnv() (Config, error) {
+	e := envReader{}
+	data := Config{
+		Delayer: Delayer{
+			Pid:           e.string("DELAYER_PID", ""),
+			TimerInterval: e.int64("DELAYER_INTERVAL", 1000),
+			AccessLog:     e.string("DELAYER_ACCESS_LOG", ""),
+			ErrorLog:      e.string("DELAYER_ERROR_LOG", ""),
+			LogLevel:      e.string("DELAYER_LOG_LEVEL", "info"),
+			BatchSize:     e.int("DELAYER_BATCH_SIZE", 0),
+			Disabled:      !e.bool("DELAYER_ENABLED", true),
+			Namespace:     e.string("DELAYER_NAMESPACE", ""),
+			ReadyOrder:    e.string("DELAYER_READY_ORDER", READY_ORDER_FIFO),
+			GroupField:    e.string("DELAYER_GROUP_FIELD", "topic"),
+		},
+		Redis: Redis{
+			Host:            e.string("REDIS_HOST", "127.0.0.1"),
+			Port:            e.string("REDIS_PORT", "6379"),
+			Database:        e.int("REDIS_DB", 0),
+			DatabaseSet:     e.string("REDIS_DB", "") != "",
+			Password:        e.string("REDIS_PASSWORD", ""),
+			PasswordFile:    e.string("REDIS_PASSWORD_FILE", ""),
+			MaxIdle:         e.int("REDIS_MAX_IDLE", 2),
+			MaxActive:       e.int("REDIS_MAX_ACTIVE", 20),
+			IdleTimeout:     e.int64("REDIS_IDLE_TIMEOUT", 3600),
+			ConnMaxLifetime: e.int64("REDIS_CONN_MAX_LIFETIME", 3600),
+			DialTimeout:     e.int64("REDIS_DIAL_TIMEOUT", 5),
+			ReadTimeout:     e.int64("REDIS_READ_TIMEOUT", 5),
+			WriteTimeout:    e.int64("REDIS_WRITE_TIMEOUT", 5),
+			Cluster:         e.bool("REDIS_CLUSTER", false),
+			Compat:          e.bool("REDIS_COMPAT", false),
+			Redial:          e.bool("REDIS_REDIAL", true),
+			CrossSlot:       e.bool("REDIS_CROSS_SLOT", false),
+		},
+	}
+	if e.err != nil {
+		return Config{}, e.err
+	}
+	if err := data.Validate(); err != nil {
+		return Config{}, err
+	}
+	return data, nil
+}
+
+// 环境变量读取, 记录第一个解析错误
+type envReader struct {
+	err error
+}
+
+// 读取字符串
+func (p *envReader) string(name string, def string) string {
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return def
+	}
+	return value
+}
+
+// 读取整型
+func (p *envReader) int(name string, def int) int {
+	return int(p.int64(name, int64(def)))
+}
+
+// 读取64位整型
+func (p *envReader) int64(name string, def int64) int64 {
+	value := p.string(name, "")
+	if value == "" {
+		return def
+	}
+	i, err := StringToInt64(value)
+	if err != nil {
+		p.fail(name, value)
+		return def
+	}
+	return i
+}
+
+// 读取布尔值
+func (p *envReader) bool(name string, def bool) bool {
+	value := p.string(name, "")
+	if value == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		p.fail(name, value)
+		return def
+	}
+	return b
+}
+
+// 记录错误
+func (p *envReader) fail(name string, value string) {
+	if p.err == nil {
+		p.err = fmt.Errorf("invalid environment variable %s: %s", name, value)
+	}
+}
@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"os"
+	"testing"
+)
+
+// 设置环境变量, 测试结束时恢复
+func setEnv(t *testing.T, name string, value string) {
+	old, ok := os.LookupEnv(name)
+	os.Setenv(name, value)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(name, old)
+		} else {
+			os.Unsetenv(name)
+		}
+	})
+}
+
+func TestConfigFromEnvDefaults(t *testing.T) {
+	config, err := ConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Delayer.TimerInterval != 1000 {
+		t.Errorf("expected interval 1000, got %d", config.Delayer.TimerInterval)
+	}
+	if config.Delayer.LogLevel != "info" || config.Delayer.ReadyOrder != READY_ORDER_FIFO || config.Delayer.GroupField != "topic" {
+		t.Errorf("unexpected delayer defaults %+v", config.Delayer)
+	}
+	if config.Delayer.Disabled {
+		t.Error("expected the timer to be enabled by default")
+	}
+	if config.Redis.Host != "127.0.0.1" || config.Redis.Port != "6379" {
+		t.Errorf("unexpected redis address %s:%s", config.Redis.Host, config.Redis.Port)
+	}
+	if config.Redis.DatabaseSet {
+		t.Error("expected the database to be unset by default")
+	}
+	if config.Redis.MaxIdle != 2 || config.Redis.MaxActive != 20 || !config.Redis.Redial {
+		t.Errorf("unexpected redis defaults %+v", config.Redis)
+	}
+}
+
+func TestConfigFromEnvOverrides(t *testing.T) {
+	setEnv(t, "DELAYER_INTERVAL", "250")
+	setEnv(t, "DELAYER_ENABLED", "false")
+	setEnv(t, "DELAYER_READY_ORDER", READY_ORDER_LIFO)
+	setEnv(t, "REDIS_HOST", "redis.local")
+	setEnv(t, "REDIS_DB", "0")
+	setEnv(t, "REDIS_CROSS_SLOT", "true")
+	config, err := ConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Delayer.TimerInterval != 250 || !config.Delayer.Disabled || config.Delayer.ReadyOrder != READY_ORDER_LIFO {
+		t.Errorf("delayer overrides not applied: %+v", config.Delayer)
+	}
+	if config.Redis.Host != "redis.local" || !config.Redis.CrossSlot {
+		t.Errorf("redis overrides not applied: %+v", config.Redis)
+	}
+	// 显式配置 0 号数据库
+	if !config.Redis.DatabaseSet || config.Redis.Database != 0 {
+		t.Errorf("expected database 0 to be set, got %d (%v)", config.Redis.Database, config.Redis.DatabaseSet)
+	}
+}
+
+func TestConfigFromEnvInvalid(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		value string
+	}{
+		{"DELAYER_INTERVAL", "abc"},
+		{"DELAYER_INTERVAL", "0"},
+		{"REDIS_CLUSTER", "maybe"},
+		{"DELAYER_READY_ORDER", "random"},
+		{"REDIS_MAX_IDLE", "50"},
+	} {
+		c := c
+		t.Run(c.name+"="+c.value, func(t *testing.T) {
+			setEnv(t, c.name, c.value)
+			if _, err := ConfigFromEnv(); err == nil {
+				t.Fatalf("expected an error for %s=%s", c.name, c.value)
+			}
+		})
+	}
+}
+
+func TestDatabaseSetFromEnv(t *testing.T) {
+	setEnv(t, "REDIS_DB", "")
+	config, err := ConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Redis.DatabaseSet {
+		t.Fatal("expected the database unset without REDIS_DB")
+	}
+	setEnv(t, "REDIS_DB", "0")
+	config, err = ConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !config.Redis.DatabaseSet || config.Redis.Database != 0 {
+		t.Fatalf("expected an explicit db 0, got %d (set: %v)", config.Redis.Database, config.Redis.DatabaseSet)
+	}
+}
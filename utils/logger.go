@@ -7,14 +7,59 @@ import (
 	"os"
 )
 
-// 日志类
-type Logger struct {
+// 日志级别
+const (
+	LOG_LEVEL_ERROR = iota
+	LOG_LEVEL_WARN
+	LOG_LEVEL_INFO
+	LOG_LEVEL_DEBUG
+)
+
+// 日志接口
+type Logger interface {
+	Debug(message string)
+	Info(message string)
+	Warn(message string)
+	Error(message string, exit bool)
+	// 写出缓冲中的日志
+	Flush()
+}
+
+// 日志属性
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// 创建日志属性
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// 支持结构化属性的日志接口, 可选实现
+type FieldLogger interface {
+	Logger
+	// 返回附加属性的日志实例
+	With(fields ...Field) Logger
+}
+
+// 附加日志属性, 未实现 FieldLogger 时原样返回, 属性仅体现在消息中
+func WithFields(logger Logger, fields ...Field) Logger {
+	if l, ok := logger.(FieldLogger); ok {
+		return l.With(fields...)
+	}
+	return logger
+}
+
+// 文件日志类
+type FileLogger struct {
 	AccessLog string
 	ErrorLog  string
+	Level     int
 }
 
 // 打开文件
-func (p *Logger) openFile(fileName string) *os.File {
+func (p *FileLogger) openFile(fileName string) *os.File {
 	logFile, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
 	if err != nil {
 		log.Fatalln(fmt.Sprintf("Open file Failed: %s", fileName))
@@ -22,44 +67,85 @@ func (p *Logger) openFile(fileName string) *os.File {
 	return logFile
 }
 
-// 信息日志
-func (p *Logger) Info(message string) {
-	fileName := p.AccessLog
-	var out io.Writer
+// 创建输出
+func (p *FileLogger) newLogger(fileName string, prefix string) (*log.Logger, *os.File) {
 	if fileName == "" {
-		out = os.Stdout
-	} else {
-		logFile := p.openFile(fileName)
+		return log.New(os.Stdout, prefix, log.LstdFlags), nil
+	}
+	logFile := p.openFile(fileName)
+	out := io.MultiWriter(os.Stdout, logFile)
+	return log.New(out, prefix, log.LstdFlags), logFile
+}
+
+// 写入日志
+func (p *FileLogger) write(fileName string, prefix string, message string) {
+	logLogger, logFile := p.newLogger(fileName, prefix)
+	if logFile != nil {
 		defer logFile.Close()
-		out = io.MultiWriter(os.Stdout, logFile)
 	}
-	logLogger := log.New(out, "[info] ", log.LstdFlags)
 	logLogger.Println(message)
 }
 
-// 错误日志
-func (p *Logger) Error(message string, exit bool) {
-	fileName := p.ErrorLog
-	var out io.Writer
-	if fileName == "" {
-		out = os.Stdout
-	} else {
-		logFile := p.openFile(fileName)
+// 调试日志
+func (p *FileLogger) Debug(message string) {
+	if p.Level < LOG_LEVEL_DEBUG {
+		return
+	}
+	p.write(p.AccessLog, "[debug] ", message)
+}
+
+// 信息日志
+func (p *FileLogger) Info(message string) {
+	if p.Level < LOG_LEVEL_INFO {
+		return
+	}
+	p.write(p.AccessLog, "[info] ", message)
+}
+
+// 警告日志
+func (p *FileLogger) Warn(message string) {
+	if p.Level < LOG_LEVEL_WARN {
+		return
+	}
+	p.write(p.ErrorLog, "[warn] ", message)
+}
+
+// 错误日志, 任何级别都会输出
+func (p *FileLogger) Error(message string, exit bool) {
+	logLogger, logFile := p.newLogger(p.ErrorLog, "[error] ")
+	if logFile != nil {
 		defer logFile.Close()
-		out = io.MultiWriter(logFile, os.Stdout)
 	}
-	logLogger := log.New(out, "[error] ", log.LstdFlags)
 	if exit {
 		logLogger.Fatalln(message)
 	}
 	logLogger.Println(message)
 }
 
+// 刷新, 每次写入都直接落盘, 无需处理
+func (p *FileLogger) Flush() {
+}
+
+// 解析日志级别, 未知级别视为 info
+func ParseLogLevel(level string) int {
+	switch level {
+	case "error":
+		return LOG_LEVEL_ERROR
+	case "warn":
+		return LOG_LEVEL_WARN
+	case "debug":
+		return LOG_LEVEL_DEBUG
+	default:
+		return LOG_LEVEL_INFO
+	}
+}
+
 // 创建实例
 func NewLogger(config Config) Logger {
-	logger := Logger{
+	logger := &FileLogger{
 		AccessLog: config.Delayer.AccessLog,
 		ErrorLog:  config.Delayer.ErrorLog,
+		Level:     ParseLogLevel(config.Delayer.LogLevel),
 	}
 	return logger
 }
@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 读取日志文件, 文件不存在时返回空
+func readLog(fileName string) string {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+func TestLogLevelSuppressesLogs(t *testing.T) {
+	for _, c := range []struct {
+		level    string
+		expected []string
+		dropped  []string
+	}{
+		{"error", []string{"[error]"}, []string{"[info]", "[debug]", "[warn]"}},
+		{"warn", []string{"[error]", "[warn]"}, []string{"[info]", "[debug]"}},
+		{"info", []string{"[error]", "[warn]", "[info]"}, []string{"[debug]"}},
+		{"debug", []string{"[error]", "[warn]", "[info]", "[debug]"}, nil},
+	} {
+		dir := t.TempDir()
+		config := Config{Delayer: Delayer{
+			LogLevel:  c.level,
+			AccessLog: filepath.Join(dir, "access.log"),
+			ErrorLog:  filepath.Join(dir, "error.log"),
+		}}
+		logger := NewLogger(config)
+		logger.Debug("debug message")
+		logger.Info("info message")
+		logger.Warn("warn message")
+		logger.Error("error message", false)
+		logger.Flush()
+		output := readLog(config.Delayer.AccessLog) + readLog(config.Delayer.ErrorLog)
+		for _, prefix := range c.expected {
+			if !strings.Contains(output, prefix) {
+				t.Errorf("level %s: expected %s logs, got %q", c.level, prefix, output)
+			}
+		}
+		for _, prefix := range c.dropped {
+			if strings.Contains(output, prefix) {
+				t.Errorf("level %s: expected %s logs to be suppressed, got %q", c.level, prefix, output)
+			}
+		}
+	}
+}
+
+func TestParseLogLevel(t *testing.T) {
+	for level, expected := range map[string]int{
+		"error":   LOG_LEVEL_ERROR,
+		"warn":    LOG_LEVEL_WARN,
+		"info":    LOG_LEVEL_INFO,
+		"debug":   LOG_LEVEL_DEBUG,
+		"":        LOG_LEVEL_INFO,
+		"verbose": LOG_LEVEL_INFO,
+	} {
+		if actual := ParseLogLevel(level); actual != expected {
+			t.Errorf("level %q: expected %d, got %d", level, expected, actual)
+		}
+	}
+}
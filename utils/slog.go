@@ -0,0 +1,75 @@
+//go:build go1.21
+
+package utils
+
+import (
+	"log/slog"
+	"os"
+)
+
+// slog 日志类
+type SlogLogger struct {
+	Logger *slog.Logger
+}
+
+// 调试日志
+func (p *SlogLogger) Debug(message string) {
+	p.Logger.Debug(message)
+}
+
+// 信息日志
+func (p *SlogLogger) Info(message string) {
+	p.Logger.Info(message)
+}
+
+// 警告日志
+func (p *SlogLogger) Warn(message string) {
+	p.Logger.Warn(message)
+}
+
+// 错误日志
+func (p *SlogLogger) Error(message string, exit bool) {
+	p.Logger.Error(message, slog.Bool("exit", exit))
+	if exit {
+		os.Exit(1)
+	}
+}
+
+// 附加属性
+func (p *SlogLogger) With(fields ...Field) Logger {
+	args := make([]interface{}, 0, len(fields)*2)
+	for _, field := range fields {
+		args = append(args, field.Key, field.Value)
+	}
+	return &SlogLogger{
+		Logger: p.Logger.With(args...),
+	}
+}
+
+// 刷新, 由 slog.Handler 负责输出, 无需处理
+func (p *SlogLogger) Flush() {
+}
+
+// 创建实例, logger 为 nil 时使用 slog.Default, 附加 service 属性
+func NewSlogLogger(logger *slog.Logger) Logger {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &SlogLogger{
+		Logger: logger.With(slog.String("service", "delayer")),
+	}
+}
+
+// 按日志级别过滤, 与配置中的 log_level 一致
+func SlogLevel(level string) slog.Leveler {
+	switch ParseLogLevel(level) {
+	case LOG_LEVEL_ERROR:
+		return slog.LevelError
+	case LOG_LEVEL_WARN:
+		return slog.LevelWarn
+	case LOG_LEVEL_DEBUG:
+		return slog.LevelDebug
+	default:
+		return slog.LevelInfo
+	}
+}
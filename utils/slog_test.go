@@ -0,0 +1,98 @@
+//go:build go1.21
+
+package utils
+
+import (
+	"context"
+	"log/slog"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// 记录日志的 slog.Handler
+type captureHandler struct {
+	mutex   *sync.Mutex
+	records *[]capturedRecord
+	attrs   []slog.Attr
+	level   slog.Leveler
+}
+
+// 记录的日志
+type capturedRecord struct {
+	Level   slog.Level
+	Message string
+	Attrs   map[string]interface{}
+}
+
+func newCaptureHandler(level slog.Leveler) *captureHandler {
+	return &captureHandler{
+		mutex:   &sync.Mutex{},
+		records: &[]capturedRecord{},
+		level:   level,
+	}
+}
+
+func (h *captureHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *captureHandler) Handle(ctx context.Context, r slog.Record) error {
+	attrs := make(map[string]interface{})
+	for _, attr := range h.attrs {
+		attrs[attr.Key] = attr.Value.Any()
+	}
+	r.Attrs(func(attr slog.Attr) bool {
+		attrs[attr.Key] = attr.Value.Any()
+		return true
+	})
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	*h.records = append(*h.records, capturedRecord{Level: r.Level, Message: r.Message, Attrs: attrs})
+	return nil
+}
+
+func (h *captureHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append(append([]slog.Attr(nil), h.attrs...), attrs...)
+	return &clone
+}
+
+func (h *captureHandler) WithGroup(name string) slog.Handler {
+	return h
+}
+
+// 已记录的日志
+func (h *captureHandler) captured() []capturedRecord {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return append([]capturedRecord(nil), *h.records...)
+}
+
+func TestSlogLoggerRecords(t *testing.T) {
+	handler := newCaptureHandler(slog.LevelDebug)
+	logger := NewSlogLogger(slog.New(handler))
+	logger.Info("moved")
+	WithFields(logger, F("topic", "mail"), F("count", 2)).Error("failed", false)
+	logger.Flush()
+	expected := []capturedRecord{
+		{Level: slog.LevelInfo, Message: "moved", Attrs: map[string]interface{}{"service": "delayer"}},
+		{Level: slog.LevelError, Message: "failed", Attrs: map[string]interface{}{"service": "delayer", "topic": "mail", "count": int64(2), "exit": false}},
+	}
+	if records := handler.captured(); !reflect.DeepEqual(records, expected) {
+		t.Fatalf("expected records %+v, got %+v", expected, records)
+	}
+}
+
+func TestSlogLevel(t *testing.T) {
+	handler := newCaptureHandler(SlogLevel("error"))
+	logger := NewSlogLogger(slog.New(handler))
+	logger.Debug("debug")
+	logger.Info("info")
+	logger.Warn("warn")
+	logger.Error("error", false)
+	records := handler.captured()
+	if len(records) != 1 || records[0].Message != "error" {
+		t.Fatalf("expected only the error record at error level, got %+v", records)
+	}
+}